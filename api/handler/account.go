@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+
+	"example.com/url-shortener/api/middleware"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) SendEmailVerification(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	if err := h.service.SendEmailVerification(c, userID); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": "verification email sent"})
+}
+
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req model.VerifyEmailReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.VerifyEmail(c, &req); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "email verified"})
+}
+
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req model.ForgotPasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.IP = c.ClientIP()
+
+	if err := h.service.ForgotPassword(c, &req); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"success": "if the email belongs to an account, a reset link was sent"})
+}
+
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req model.ResetPasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.ResetPassword(c, &req); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "password changed, please log in again"})
+}
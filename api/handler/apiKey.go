@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+
+	"example.com/url-shortener/api/middleware"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) CreateApiKey(c *gin.Context) {
+	var req model.CreateApiKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.CreateApiKey(c, userID, &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) ListApiKeys(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	res, err := h.service.ListApiKeys(c, userID)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) RevokeApiKey(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	if err := h.service.RevokeApiKey(c, userID, c.Param("id")); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "api key revoked"})
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/api/middleware"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxBulkBodyBytes leaves room for MaxBulkUrls rows of long URLs and tags.
+const maxBulkBodyBytes = 16 << 20
+
+// CreateURLs accepts a JSON array of create requests, a text/csv body or a
+// multipart upload with the CSV in a "file" field.
+func (h *Handler) CreateURLs(c *gin.Context) {
+	var urlReqs []model.CreateUrlReq
+	var err error
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBulkBodyBytes)
+
+	switch c.ContentType() {
+	case gin.MIMEMultipartPOSTForm:
+		file, ferr := c.FormFile("file")
+		if ferr != nil {
+			bulkBodyError(c, ferr)
+			return
+		}
+
+		f, ferr := file.Open()
+		if ferr != nil {
+			bulkBodyError(c, ferr)
+			return
+		}
+		defer f.Close()
+
+		urlReqs, err = parseBulkCSV(f)
+	case "text/csv":
+		urlReqs, err = parseBulkCSV(c.Request.Body)
+	default:
+		err = c.ShouldBindJSON(&urlReqs)
+	}
+
+	if err != nil {
+		bulkBodyError(c, err)
+		return
+	}
+
+	userID := middleware.UserID(c)
+	partial, _ := strconv.ParseBool(c.Query("partial"))
+
+	res, err := h.service.CreateURLs(c, userID, urlReqs, partial)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	code := http.StatusCreated
+	if res.Created == 0 {
+		code = http.StatusUnprocessableEntity
+	} else if res.Failed > 0 {
+		code = http.StatusMultiStatus
+	}
+
+	c.JSON(code, res)
+}
+
+func bulkBodyError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("body must be at most %d bytes", maxBulkBodyBytes)})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// parseBulkCSV reads rows with a header naming the columns label, long_url
+// and optionally key, domain, tags (separated by "|") and expiry (RFC 3339).
+// It stops as soon as there are more rows than one request may create.
+func parseBulkCSV(r io.Reader) ([]model.CreateUrlReq, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv: missing header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["long_url"]; !ok {
+		return nil, fmt.Errorf("csv: header must contain long_url")
+	}
+
+	get := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var urlReqs []model.CreateUrlReq
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(urlReqs) == model.MaxBulkUrls {
+			return nil, fmt.Errorf("csv: at most %d urls per request", model.MaxBulkUrls)
+		}
+
+		urlReq := model.CreateUrlReq{
+			Label:       get(row, "label"),
+			LongURL:     get(row, "long_url"),
+			ShortURLKey: get(row, "key"),
+			Domain:      get(row, "domain"),
+		}
+
+		if tags := get(row, "tags"); tags != "" {
+			for _, tag := range strings.Split(tags, "|") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					urlReq.Tags = append(urlReq.Tags, tag)
+				}
+			}
+		}
+
+		if expiry := get(row, "expiry"); expiry != "" {
+			t, err := time.Parse(time.RFC3339, expiry)
+			if err != nil {
+				return nil, fmt.Errorf("csv: line %d: invalid expiry %q", line, expiry)
+			}
+			urlReq.ExpiresAt = &t
+		}
+
+		urlReqs = append(urlReqs, urlReq)
+	}
+
+	return urlReqs, nil
+}
@@ -5,10 +5,10 @@ import (
 	"net/http"
 	"time"
 
+	"example.com/url-shortener/api/middleware"
 	"example.com/url-shortener/internal/model"
 	"example.com/url-shortener/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/mssola/useragent"
 )
 
 type Handler struct {
@@ -28,7 +28,7 @@ func (h *Handler) Signup(c *gin.Context) {
 		return
 	}
 
-	res, err := h.service.Signup(c, &user)
+	res, err := h.service.Signup(c, &user, sessionMeta(c))
 	if err != nil {
 		// c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		utils.CjsonError(c, err)
@@ -45,7 +45,6 @@ func (h *Handler) Signup(c *gin.Context) {
 		Secure:   true,
 		SameSite: http.SameSiteNoneMode,
 	}
-	
 
 	http.SetCookie(c.Writer, &cookie)
 
@@ -60,7 +59,7 @@ func (h *Handler) Login(c *gin.Context) {
 		return
 	}
 
-	res, err := h.service.Login(c, &loginReq)
+	res, err := h.service.Login(c, &loginReq, sessionMeta(c))
 	if err != nil {
 		utils.CjsonError(c, err)
 		return
@@ -76,28 +75,21 @@ func (h *Handler) Login(c *gin.Context) {
 		Secure:   true,
 		SameSite: http.SameSiteNoneMode,
 	}
-	
-	http.SetCookie(c.Writer, &cookie)	
-	
+
+	http.SetCookie(c.Writer, &cookie)
+
 	c.JSON(http.StatusOK, res)
 }
 
 func (h *Handler) Logout(c *gin.Context) {
 
-	token, err := c.Cookie("token")
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-		return
-	}
-
-	err = h.service.Logout(c, token)
+	err := h.service.Logout(c, middleware.UserID(c), middleware.Principal(c).SessionID)
 	if err != nil {
 		utils.CjsonError(c, err)
 		return
 	}
 
 	c.SetCookie("token", "", -1, "/", "", true, true)
-	
 
 	c.JSON(http.StatusOK, gin.H{"success": "logged out successfully"})
 }
@@ -111,21 +103,183 @@ func (h *Handler) CreatURL(c *gin.Context) {
 		return
 	}
 
-	userID := c.GetString("user_id")
+	userID := middleware.UserID(c)
 
-	id, err := h.service.CreateURL(c, userID, &urlReq)
+	url, err := h.service.CreateURL(c, userID, &urlReq)
 	if err != nil {
 		utils.CjsonError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"url_id": id})
+	c.JSON(http.StatusCreated, gin.H{"url_id": url.UrlID, "short_url_key": url.ShortURLKey})
 }
 
 func (h *Handler) GetAllURLs(c *gin.Context) {
-	userID := c.GetString("user_id")
+	var query model.ListUrlsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.GetAllURLs(c, userID, &query)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) ListClicks(c *gin.Context) {
+	var filter model.ClickEventFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.ListClickEvents(c, userID, c.Query("domain"), c.Param("key"), &filter)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) GetURLStats(c *gin.Context) {
+	var query model.UrlStatsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.GetURLStats(c, userID, c.Param("key"), &query)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) ExportURLs(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+
+	switch format {
+	case "csv":
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	case "jsonl":
+		c.Header("Content-Type", "application/x-ndjson")
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or jsonl"})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="urls.`+format+`"`)
+
+	userID := middleware.UserID(c)
+
+	err := h.service.ExportURLs(c, userID, format, c.Writer)
+	if err != nil {
+		// Once rows have been streamed the status can no longer change.
+		if c.Writer.Written() {
+			log.Printf("export for user %s aborted: %v", userID, err)
+			return
+		}
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		utils.CjsonError(c, err)
+	}
+}
+
+func (h *Handler) UpdateURL(c *gin.Context) {
+	var req model.UpdateUrlReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.UpdateURL(c, userID, c.Param("id"), &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) DisableURL(c *gin.Context) {
+	h.setURLDisabled(c, true)
+}
+
+func (h *Handler) EnableURL(c *gin.Context) {
+	h.setURLDisabled(c, false)
+}
+
+func (h *Handler) setURLDisabled(c *gin.Context, disabled bool) {
+	userID := middleware.UserID(c)
+
+	res, err := h.service.SetURLDisabled(c, userID, c.Param("id"), disabled)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) DeleteURL(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	if err := h.service.DeleteURL(c, userID, c.Param("id")); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "url deleted"})
+}
+
+func (h *Handler) AddDomain(c *gin.Context) {
+	var req model.CreateDomainReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.AddDomain(c, userID, &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) ListDomains(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	res, err := h.service.ListDomains(c, userID)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
 
-	res, err := h.service.GetAllURLs(c, userID)
+func (h *Handler) VerifyDomain(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	res, err := h.service.VerifyDomain(c, userID, c.Param("id"))
 	if err != nil {
 		utils.CjsonError(c, err)
 		return
@@ -134,10 +288,44 @@ func (h *Handler) GetAllURLs(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+func (h *Handler) ListBlockRules(c *gin.Context) {
+	res, err := h.service.ListBlockRules(c)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) AddBlockRule(c *gin.Context) {
+	var req model.CreateBlockRuleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := h.service.AddBlockRule(c, &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) RemoveBlockRule(c *gin.Context) {
+	if err := h.service.RemoveBlockRule(c, c.Param("id")); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "rule removed"})
+}
+
 func (h *Handler) Refresh(c *gin.Context) {
 	refreshToken := c.GetHeader("refresh-token")
-	log.Println(refreshToken)
-	access_token, err := h.service.RefreshAccessToken(c, refreshToken)
+	tokens, err := h.service.RefreshAccessToken(c, refreshToken, sessionMeta(c))
 	if err != nil {
 		utils.CjsonError(c, err)
 		return
@@ -145,7 +333,7 @@ func (h *Handler) Refresh(c *gin.Context) {
 
 	cookie := http.Cookie{
 		Name:     "token",
-		Value:    *access_token,
+		Value:    tokens.AccessToken,
 		Expires:  time.Now().Add(10 * time.Minute),
 		Path:     "/",
 		Domain:   "localhost",
@@ -156,32 +344,130 @@ func (h *Handler) Refresh(c *gin.Context) {
 
 	http.SetCookie(c.Writer, &cookie)
 
-	c.JSON(http.StatusOK, gin.H{"success": "new access token set in cookie"})
+	// The refresh token was rotated, so the client must keep the new one.
+	c.JSON(http.StatusOK, tokens)
 }
 
-func (h *Handler) RedirectURL(c *gin.Context) {
-	key := c.Param("key")
+func sessionMeta(c *gin.Context) *model.SessionMeta {
+	return &model.SessionMeta{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
+func (h *Handler) ListSessions(c *gin.Context) {
+	principal := middleware.Principal(c)
+
+	res, err := h.service.ListSessions(c, principal.UserID, principal.SessionID)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
 
-	ua := c.Request.UserAgent()
-	userAgent := useragent.New(ua)
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID := middleware.UserID(c)
 
+	if err := h.service.RevokeSession(c, userID, c.Param("id")); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "session revoked"})
+}
+
+func (h *Handler) RedirectURL(c *gin.Context) {
 	ip := c.ClientIP()
 	if ip == "127.0.0.1" || ip == "::1" {
 		ip = "157.51.198.201"
 	}
 
-	device := "Android"
-	if userAgent.OSInfo().Name != "" {
-		device = userAgent.OSInfo().Name
+	click := &model.ClickEvent{
+		Key:       c.Param("key"),
+		Domain:    c.Request.Host,
+		IP:        ip,
+		UserAgent: c.Request.UserAgent(),
+		Referrer:  c.Request.Referer(),
+		UTM: model.UTM{
+			Source:   c.Query("utm_source"),
+			Medium:   c.Query("utm_medium"),
+			Campaign: c.Query("utm_campaign"),
+			Term:     c.Query("utm_term"),
+			Content:  c.Query("utm_content"),
+		},
 	}
-	redirectURl, err := h.service.RedirectURL(c, key, ip, device)
+
+	// Browsers post the password from the interstitial form; API clients
+	// can send it in a header on a plain GET.
+	password := c.GetHeader("X-Link-Password")
+	if password == "" {
+		password = c.PostForm("password")
+	}
+
+	confirmed := c.Query("continue") == "1"
+
+	url, err := h.service.RedirectURL(c, click, password, confirmed)
 	if err != nil {
-		
-			utils.CjsonError(c, err)
+		if url != nil && url.IsExpired(time.Now()) {
+			h.expired(c, url)
+			return
+		}
+
+		code := err.(*utils.AppError).ErrorCode()
+		if code == http.StatusNotFound && !wantsJSON(c) {
+			renderPage(c, code, notFoundTmpl, nil)
 			return
-		
+		}
+
+		if url != nil && !wantsJSON(c) && (code == http.StatusForbidden || code == http.StatusConflict) {
+			page := warningPage{Blocked: code == http.StatusForbidden}
+			if !page.Blocked {
+				query := c.Request.URL.Query()
+				query.Set("continue", "1")
+				page.Continue = c.Request.URL.Path + "?" + query.Encode()
+				code = http.StatusOK
+			}
+			renderPage(c, code, warningTmpl, page)
+			return
+		}
+
+		if url != nil && url.Protected && !wantsJSON(c) {
+			page := passwordPage{Action: c.Request.URL.Path}
+			if password != "" {
+				page.Error = err.Error()
+			}
+			renderPage(c, err.(*utils.AppError).ErrorCode(), passwordTmpl, page)
+			return
+		}
+
+		utils.CjsonError(c, err)
+		return
+	}
+
+	// The SPA resolves links over XHR and still wants the bare long URL back.
+	if wantsJSON(c) {
+		c.JSON(http.StatusOK, url.LongURL)
+		return
+	}
+
+	// A 307/308 after the password form would replay the form body,
+	// password included, to the destination.
+	if c.Request.Method == http.MethodPost {
+		c.Redirect(http.StatusSeeOther, url.LongURL)
+		return
+	}
+
+	c.Redirect(url.RedirectStatus(), url.LongURL)
+}
+
+func (h *Handler) expired(c *gin.Context, url *model.Url) {
+	if wantsJSON(c) {
+		res := gin.H{"error": "link has expired"}
+		if url.FallbackURL != "" {
+			res["fallback_url"] = url.FallbackURL
+		}
+		c.JSON(http.StatusGone, res)
+		return
 	}
 
-	
-	c.JSON(http.StatusOK, redirectURl)
+	renderPage(c, http.StatusGone, goneTmpl, url.FallbackURL)
 }
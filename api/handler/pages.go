@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var goneTmpl = template.Must(template.New("gone").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Link expired</title>
+{{if .}}<meta http-equiv="refresh" content="0; url={{.}}">{{end}}
+</head>
+<body>
+<p>This link has expired.</p>
+{{if .}}<p><a href="{{.}}">Continue</a></p>{{end}}
+</body>
+</html>
+`))
+
+var notFoundTmpl = template.Must(template.New("notFound").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Link not found</title>
+</head>
+<body>
+<p>This link does not exist or has been deleted.</p>
+</body>
+</html>
+`))
+
+type passwordPage struct {
+	Action string
+	Error  string
+}
+
+var passwordTmpl = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Password required</title>
+</head>
+<body>
+<form method="post" action="{{.Action}}">
+<p>This link is password protected.</p>
+{{if .Error}}<p>{{.Error}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+type warningPage struct {
+	Blocked  bool
+	Continue string
+}
+
+var warningTmpl = template.Must(template.New("warning").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Warning</title>
+</head>
+<body>
+{{if .Blocked}}
+<p>This link has been disabled because its destination was reported as harmful.</p>
+{{else}}
+<p>This link leads to a site that has been flagged as possibly harmful.</p>
+<p><a href="{{.Continue}}">Continue anyway</a></p>
+{{end}}
+</body>
+</html>
+`))
+
+func renderPage(c *gin.Context, code int, tmpl *template.Template, data any) {
+	c.Status(code)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(c.Writer, data); err != nil {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}
+}
+
+func wantsJSON(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON
+}
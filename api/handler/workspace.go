@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+
+	"example.com/url-shortener/api/middleware"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handler) CreateWorkspace(c *gin.Context) {
+	var req model.CreateWorkspaceReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.CreateWorkspace(c, userID, &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) ListWorkspaces(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	res, err := h.service.ListWorkspaces(c, userID)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) ListWorkspaceMembers(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	res, err := h.service.ListWorkspaceMembers(c, userID, c.Param("id"))
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) InviteMember(c *gin.Context) {
+	var req model.InviteMemberReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.InviteMember(c, userID, c.Param("id"), &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, res)
+}
+
+func (h *Handler) AcceptInvitation(c *gin.Context) {
+	var req model.AcceptInvitationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.AcceptInvitation(c, userID, &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) UpdateMember(c *gin.Context) {
+	var req model.UpdateMemberReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	res, err := h.service.UpdateMember(c, userID, c.Param("id"), c.Param("member_id"), &req)
+	if err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) RemoveMember(c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	if err := h.service.RemoveMember(c, userID, c.Param("id"), c.Param("member_id")); err != nil {
+		utils.CjsonError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": "member removed"})
+}
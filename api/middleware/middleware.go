@@ -1,29 +1,178 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
+	"strings"
 
+	"example.com/url-shortener/internal/model"
 	"example.com/url-shortener/utils"
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware(secret string) gin.HandlerFunc {
+// Authenticator checks what a signature alone can't: that an API key
+// exists and that the session behind an access token is still active.
+type Authenticator interface {
+	AuthenticateApiKey(c context.Context, key string) (*model.ApiKey, error)
+	CheckSession(c context.Context, userID string, sessionID string) error
+}
+
+const principalKey = "principal"
+
+// AuthMiddleware accepts an access token from the token cookie or from
+// "Authorization: Bearer <token>", where the token may also be an API key.
+// It stores a *model.Principal for Principal and UserID to read, and also
+// sets "user_id".
+func AuthMiddleware(secret string, auth Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method := model.AuthBearer
+		token, ok := bearerToken(c)
+		if !ok {
+			cookie, err := c.Cookie("token")
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "access token not found"})
+				c.Abort()
+				return
+			}
+			method, token = model.AuthCookie, cookie
+		}
+
+		var principal *model.Principal
+
+		if strings.HasPrefix(token, model.ApiKeyPrefix) {
+			apiKey, err := auth.AuthenticateApiKey(c, token)
+			if err != nil {
+				utils.CjsonError(c, err)
+				c.Abort()
+				return
+			}
+
+			principal = &model.Principal{
+				UserID:     apiKey.UserID.Hex(),
+				Name:       apiKey.Name,
+				Scopes:     apiKey.Scopes,
+				AuthMethod: model.AuthApiKey,
+			}
+		} else {
+			claims, err := utils.ParseAccessToken(token, secret)
+			if err != nil || claims.SessionID == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
+				c.Abort()
+				return
+			}
+
+			// Logging out or revoking a session has to end its access
+			// tokens too, not just stop them from being refreshed.
+			if err := auth.CheckSession(c, claims.UserID, claims.SessionID); err != nil {
+				utils.CjsonError(c, err)
+				c.Abort()
+				return
+			}
+
+			principal = &model.Principal{
+				UserID:     claims.UserID,
+				Name:       claims.Name,
+				AuthMethod: method,
+				SessionID:  claims.SessionID,
+			}
+		}
+
+		c.Set(principalKey, principal)
+		c.Set("user_id", principal.UserID)
+		c.Next()
+	}
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[7:])
+	return token, token != ""
+}
+
+// Principal returns who the request is made by, or nil outside
+// AuthMiddleware.
+func Principal(c *gin.Context) *model.Principal {
+	if v, ok := c.Get(principalKey); ok {
+		return v.(*model.Principal)
+	}
+	return nil
+}
+
+// UserID returns the authenticated user's ID, or "".
+func UserID(c *gin.Context) string {
+	if p := Principal(c); p != nil {
+		return p.UserID
+	}
+	return ""
+}
+
+// HasScope reports whether the principal may use scope. Sessions have
+// every scope.
+func HasScope(c *gin.Context, scope string) bool {
+	p := Principal(c)
+	if p == nil {
+		return false
+	}
+	if p.AuthMethod != model.AuthApiKey {
+		return true
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope stops API keys without scope.
+func RequireScope(scope string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token, err := c.Cookie("token")
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "cookie not found"})
+		if !HasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "api key lacks scope " + scope})
 			c.Abort()
 			return
 		}
 
-		user_id, err := utils.ValidateToken(token, secret)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
+		c.Next()
+	}
+}
+
+// SessionOnly keeps API keys away from account management, so a leaked key
+// cannot be used to mint more keys.
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p := Principal(c); p == nil || p.AuthMethod == model.AuthApiKey {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not available with an api key"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// AdminMiddleware only lets through users listed in adminIDs, a comma
+// separated list of user IDs. It must run after AuthMiddleware.
+func AdminMiddleware(adminIDs string) gin.HandlerFunc {
+	admins := make(map[string]bool)
+	for _, id := range strings.Split(adminIDs, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			admins[id] = true
+		}
+	}
+
+	return func(c *gin.Context) {
+		if !admins[UserID(c)] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", user_id)
 		c.Next()
 	}
 }
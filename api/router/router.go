@@ -1,6 +1,7 @@
 package router
 
 import (
+	"expvar"
 	"net/http"
 	"os"
 	"time"
@@ -17,8 +18,8 @@ func NewRouter(r *gin.Engine, ser model.UserServiceInterface) {
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"https://reago.netlify.app"},
-		AllowMethods:     []string{"GET", "POST"},
-		AllowHeaders:     []string{"Content-Type", "refresh-token"},
+		AllowMethods:     []string{"GET", "POST", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Content-Type", "Authorization", "refresh-token", "X-Link-Password"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 		AllowOriginFunc: func(origin string) bool {
@@ -35,13 +36,57 @@ func NewRouter(r *gin.Engine, ser model.UserServiceInterface) {
 	public.POST("/signup", h.Signup)
 	public.POST("/login", h.Login)
 	public.POST("/refresh", h.Refresh)
+	public.POST("/verify-email", h.VerifyEmail)
+	public.POST("/password/forgot", h.ForgotPassword)
+	public.POST("/password/reset", h.ResetPassword)
 	public.GET("/:key", h.RedirectURL)
+	public.POST("/:key", h.RedirectURL)
 
 	// //Protected routes
 	protected := r.Group("")
-	protected.Use(middleware.AuthMiddleware(os.Getenv("ACCESS_TOKEN_SECRET")))
-	protected.GET("/logout", h.Logout)
-	protected.POST("/create-url", h.CreatURL)
-	protected.GET("/get-all-urls", h.GetAllURLs)
+	protected.Use(middleware.AuthMiddleware(os.Getenv("ACCESS_TOKEN_SECRET"), ser))
+
+	// Link routes can also be used with an API key that has the scope.
+	read := middleware.RequireScope(model.ScopeLinksRead)
+	write := middleware.RequireScope(model.ScopeLinksWrite)
+	stats := middleware.RequireScope(model.ScopeStatsRead)
+
+	protected.POST("/create-url", write, h.CreatURL)
+	protected.GET("/get-all-urls", read, h.GetAllURLs)
+	protected.GET("/urls/export", read, h.ExportURLs)
+	protected.GET("/urls/:key/clicks", stats, h.ListClicks)
+	protected.GET("/urls/:key/stats", stats, h.GetURLStats)
+	protected.POST("/urls/bulk", write, h.CreateURLs)
+	protected.PATCH("/urls/:id", write, h.UpdateURL)
+	protected.DELETE("/urls/:id", write, h.DeleteURL)
+	protected.POST("/urls/:id/disable", write, h.DisableURL)
+	protected.POST("/urls/:id/enable", write, h.EnableURL)
+
+	account := protected.Group("")
+	account.Use(middleware.SessionOnly())
+	account.GET("/logout", h.Logout)
+	account.POST("/verify-email/resend", h.SendEmailVerification)
+	account.GET("/domains", h.ListDomains)
+	account.POST("/domains", h.AddDomain)
+	account.POST("/domains/:id/verify", h.VerifyDomain)
+	account.GET("/workspaces", h.ListWorkspaces)
+	account.POST("/workspaces", h.CreateWorkspace)
+	account.POST("/workspaces/invitations/accept", h.AcceptInvitation)
+	account.GET("/workspaces/:id/members", h.ListWorkspaceMembers)
+	account.POST("/workspaces/:id/invitations", h.InviteMember)
+	account.PATCH("/workspaces/:id/members/:member_id", h.UpdateMember)
+	account.DELETE("/workspaces/:id/members/:member_id", h.RemoveMember)
+	account.GET("/api-keys", h.ListApiKeys)
+	account.POST("/api-keys", h.CreateApiKey)
+	account.DELETE("/api-keys/:id", h.RevokeApiKey)
+	account.GET("/sessions", h.ListSessions)
+	account.DELETE("/sessions/:id", h.RevokeSession)
+
+	admin := account.Group("/admin")
+	admin.Use(middleware.AdminMiddleware(os.Getenv("ADMIN_USER_IDS")))
+	admin.GET("/blocklist", h.ListBlockRules)
+	admin.POST("/blocklist", h.AddBlockRule)
+	admin.DELETE("/blocklist/:id", h.RemoveBlockRule)
+	admin.GET("/metrics", gin.WrapH(expvar.Handler()))
 
 }
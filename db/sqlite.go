@@ -0,0 +1,39 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	_ "modernc.org/sqlite"
+)
+
+// NewSQLiteDatabase opens the SQLite file at SQLITE_PATH, creating it if
+// needed. WAL lets readers carry on while a write is in progress, and
+// immediate transactions take the write lock up front so concurrent
+// writers wait on busy_timeout instead of failing.
+func NewSQLiteDatabase() *sql.DB {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "url-shortener.db"
+	}
+
+	dsn := "file:" + path +
+		"?_pragma=foreign_keys(1)" +
+		"&_pragma=journal_mode(WAL)" +
+		"&_pragma=busy_timeout(5000)" +
+		"&_txlock=immediate"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := db.Ping(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("DB connection successful")
+
+	return db
+}
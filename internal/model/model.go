@@ -2,9 +2,15 @@ package model
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -22,71 +28,718 @@ type LoginUserReq struct {
 type SignupLoginUserRes struct {
 	UserID       primitive.ObjectID `json:"user_id"`
 	FullName     string             `json:"full_name"`
-	Email string `json:"email"`
+	Email        string             `json:"email"`
 	AccessToken  string             `json:"access_token"`
 	RefreshToken string             `json:"refresh_token"`
+
+	EmailVerified bool `json:"email_verified"`
 }
 
 type Url struct {
-	UrlID       primitive.ObjectID `json:"url_id" bson:"_id"`
-	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
-	Label       string             `json:"label" bson:"label"`
-	LongURL     string             `json:"long_url" bson:"long_url"`
-	ShortURLKey string             `json:"short_url_key" bson:"short_url_key"`
-	NoOfClicks  int                `json:"no_of_clicks" bson:"no_of_clicks"`
-	Device      map[string]int     `json:"device"`
-	Location    map[string]int     `json:"location"`
-	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UrlID        primitive.ObjectID  `json:"url_id" bson:"_id"`
+	UserID       primitive.ObjectID  `json:"user_id" bson:"user_id"`
+	WorkspaceID  *primitive.ObjectID `json:"workspace_id,omitempty" bson:"workspace_id,omitempty"`
+	Label        string              `json:"label" bson:"label"`
+	LongURL      string              `json:"long_url" bson:"long_url"`
+	ShortURLKey  string              `json:"short_url_key" bson:"short_url_key"`
+	Domain       string              `json:"domain,omitempty" bson:"domain,omitempty"`
+	RedirectType int                 `json:"redirect_type" bson:"redirect_type"`
+	ExpiresAt    *time.Time          `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	MaxClicks    int                 `json:"max_clicks,omitempty" bson:"max_clicks,omitempty"`
+	FallbackURL  string              `json:"fallback_url,omitempty" bson:"fallback_url,omitempty"`
+	Expired      bool                `json:"expired" bson:"expired"`
+	Protected    bool                `json:"protected" bson:"protected"`
+	PasswordHash string              `json:"-" bson:"password_hash,omitempty"`
+	Disabled     bool                `json:"disabled" bson:"disabled"`
+	DeletedAt    *time.Time          `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	Tags         []string            `json:"tags,omitempty" bson:"tags,omitempty"`
+	NoOfClicks   int                 `json:"no_of_clicks" bson:"no_of_clicks"`
+	Device       Breakdown           `json:"device"`
+	Location     Breakdown           `json:"location"`
+	CreatedAt    time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// Breakdown counts a link's clicks per OS or city. The names come from
+// requests, and Mongo reads "." in a field name as a path and refuses a
+// leading "$", so they are escaped in stored documents.
+type Breakdown map[string]int
+
+var (
+	breakdownEscaper   = strings.NewReplacer("%", "%25", ".", "%2E", "$", "%24")
+	breakdownUnescaper = strings.NewReplacer("%25", "%", "%2E", ".", "%24", "$")
+)
+
+// BreakdownField is the field name name is stored under. An empty name is
+// stored as a lone "%", which no escaped name can be.
+func BreakdownField(name string) string {
+	if name == "" {
+		return "%"
+	}
+	return breakdownEscaper.Replace(name)
+}
+
+func breakdownName(field string) string {
+	if field == "%" {
+		return ""
+	}
+	return breakdownUnescaper.Replace(field)
+}
+
+func (b Breakdown) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if b == nil {
+		return bsontype.Null, nil, nil
+	}
+
+	doc := make(bson.M, len(b))
+	for name, n := range b {
+		doc[BreakdownField(name)] = n
+	}
+	return bson.MarshalValue(doc)
+}
+
+// UnmarshalBSONValue also reads documents written before names were
+// escaped, where a name with a "." became nested documents.
+func (b *Breakdown) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.Null || t == bsontype.Undefined {
+		*b = nil
+		return nil
+	}
+
+	var doc bson.Raw
+	if err := (bson.RawValue{Type: t, Value: data}).Unmarshal(&doc); err != nil {
+		return err
+	}
+
+	*b = make(Breakdown)
+	return b.add("", doc)
+}
+
+func (b Breakdown) add(prefix string, doc bson.Raw) error {
+	elems, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+
+	for _, elem := range elems {
+		name := prefix + breakdownName(elem.Key())
+		value := elem.Value()
+
+		switch value.Type {
+		case bsontype.EmbeddedDocument:
+			if err := b.add(name+".", value.Document()); err != nil {
+				return err
+			}
+		case bsontype.Int32:
+			b[name] += int(value.Int32())
+		case bsontype.Int64:
+			b[name] += int(value.Int64())
+		case bsontype.Double:
+			b[name] += int(value.Double())
+		}
+	}
+
+	return nil
 }
 
 type CreateUrlReq struct {
-	Label       string `json:"label"`
-	LongURL     string `json:"long_url"`
-	ShortURLKey string `json:"short_url_key"`
+	Label        string     `json:"label"`
+	LongURL      string     `json:"long_url"`
+	ShortURLKey  string     `json:"short_url_key"`
+	RedirectType int        `json:"redirect_type"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxClicks    int        `json:"max_clicks"`
+	FallbackURL  string     `json:"fallback_url"`
+	Password     string     `json:"password"`
+	Tags         []string   `json:"tags"`
+	Domain       string     `json:"domain"`
+	WorkspaceID  string     `json:"workspace_id"`
+}
+
+// BulkUrlResult reports the outcome of one row of a bulk create, numbered
+// from 1 in the order the rows were sent.
+type BulkUrlResult struct {
+	Row         int    `json:"row"`
+	UrlID       string `json:"url_id,omitempty"`
+	ShortURLKey string `json:"short_url_key,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// MaxBulkUrls is how many links one bulk request may create.
+const MaxBulkUrls = 5000
+
+type BulkUrlRes struct {
+	Created int             `json:"created"`
+	Failed  int             `json:"failed"`
+	Results []BulkUrlResult `json:"results"`
+}
+
+// ListUrlsQuery pages through a user's links. Sort is one of created_at
+// (default), clicks or label; Status is one of enabled, disabled, expired or
+// active. After is decoded from Cursor by the service.
+type ListUrlsQuery struct {
+	Limit       int       `form:"limit"`
+	Cursor      string    `form:"cursor"`
+	Sort        string    `form:"sort"`
+	Order       string    `form:"order"`
+	Label       string    `form:"label"`
+	Domain      string    `form:"domain"`
+	CreatedFrom time.Time `form:"created_from" time_format:"2006-01-02T15:04:05Z07:00"`
+	CreatedTo   time.Time `form:"created_to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Status      string    `form:"status"`
+	WorkspaceID string    `form:"workspace_id"`
+
+	After     *UrlCursor          `form:"-"`
+	Workspace *primitive.ObjectID `form:"-"`
+}
+
+// UrlCursor is the position of the last link on a page: its value for the
+// sort field plus its ID to break ties. Order and Filters record the query
+// it came from, so it can't be replayed against a different one.
+type UrlCursor struct {
+	Sort      string             `json:"s"`
+	Order     string             `json:"o"`
+	Filters   string             `json:"f"`
+	CreatedAt time.Time          `json:"c,omitempty"`
+	Clicks    int                `json:"n,omitempty"`
+	Label     string             `json:"l,omitempty"`
+	ID        primitive.ObjectID `json:"id"`
+}
+
+type UrlPage struct {
+	Urls       []Url  `json:"urls"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// UpdateUrlReq is a partial update; fields left out of the request body are
+// not changed. An empty password removes the protection.
+type UpdateUrlReq struct {
+	Label        *string    `json:"label"`
+	LongURL      *string    `json:"long_url"`
+	RedirectType *int       `json:"redirect_type"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	MaxClicks    *int       `json:"max_clicks"`
+	FallbackURL  *string    `json:"fallback_url"`
+	Password     *string    `json:"password"`
+}
+
+// RedirectStatus is the HTTP status used when redirecting to the long URL.
+// Links stored before redirect types existed fall back to 302.
+func (u *Url) RedirectStatus() int {
+	if u.RedirectType == 0 {
+		return http.StatusFound
+	}
+	return u.RedirectType
+}
+
+// Clone returns a copy of u that shares nothing with it.
+func (u *Url) Clone() *Url {
+	c := *u
+	c.Tags = append([]string(nil), u.Tags...)
+
+	c.Device = make(Breakdown, len(u.Device))
+	for k, v := range u.Device {
+		c.Device[k] = v
+	}
+	c.Location = make(Breakdown, len(u.Location))
+	for k, v := range u.Location {
+		c.Location[k] = v
+	}
+
+	if u.WorkspaceID != nil {
+		id := *u.WorkspaceID
+		c.WorkspaceID = &id
+	}
+	if u.ExpiresAt != nil {
+		at := *u.ExpiresAt
+		c.ExpiresAt = &at
+	}
+	if u.DeletedAt != nil {
+		at := *u.DeletedAt
+		c.DeletedAt = &at
+	}
+
+	return &c
+}
+
+// IsExpired reports whether the link has passed its expiry date or used up
+// its clicks. The sweeper persists this as Expired, but redirects check it
+// directly so a link stops working the moment it runs out.
+func (u *Url) IsExpired(now time.Time) bool {
+	if u.Expired {
+		return true
+	}
+	if u.ExpiresAt != nil && !now.Before(*u.ExpiresAt) {
+		return true
+	}
+	return u.MaxClicks > 0 && u.NoOfClicks >= u.MaxClicks
+}
+
+// ClickEvent is one redirect, captured on the request path and enriched
+// by the click pipeline before it is stored. The raw IP never leaves memory;
+// only its salted hash is persisted.
+type ClickEvent struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	Key        string             `json:"key" bson:"key"`
+	Domain     string             `json:"domain,omitempty" bson:"domain,omitempty"`
+	At         time.Time          `json:"at" bson:"at"`
+	IP         string             `json:"-" bson:"-"`
+	UserAgent  string             `json:"-" bson:"-"`
+	IPHash     string             `json:"ip_hash" bson:"ip_hash"`
+	Country    string             `json:"country" bson:"country"`
+	City       string             `json:"city" bson:"city"`
+	OS         string             `json:"os" bson:"os"`
+	Browser    string             `json:"browser" bson:"browser"`
+	DeviceType string             `json:"device_type" bson:"device_type"`
+	Referrer   string             `json:"referrer" bson:"referrer"`
+	UTM        UTM                `json:"utm" bson:"utm"`
+	// Counted is set when the redirect already took the click from a
+	// link's limit, so recording it must not bump no_of_clicks again.
+	Counted bool `json:"-" bson:"-"`
+}
+
+type UTM struct {
+	Source   string `json:"source,omitempty" bson:"source,omitempty"`
+	Medium   string `json:"medium,omitempty" bson:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty" bson:"campaign,omitempty"`
+	Term     string `json:"term,omitempty" bson:"term,omitempty"`
+	Content  string `json:"content,omitempty" bson:"content,omitempty"`
+}
+
+// ClickEventFilter selects click events for a single link. Query fields
+// are bound from the request; Key, Before and Limit are set by the service.
+type ClickEventFilter struct {
+	From        time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To          time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Country     string    `form:"country"`
+	City        string    `form:"city"`
+	OS          string    `form:"os"`
+	Browser     string    `form:"browser"`
+	DeviceType  string    `form:"device_type"`
+	Referrer    string    `form:"referrer"`
+	UTMSource   string    `form:"utm_source"`
+	UTMMedium   string    `form:"utm_medium"`
+	UTMCampaign string    `form:"utm_campaign"`
+	Cursor      string    `form:"cursor"`
+	Limit       int       `form:"limit"`
+
+	Key    string             `form:"-"`
+	Domain string             `form:"-"`
+	Before primitive.ObjectID `form:"-"`
+}
+
+// ClickAggregate counts the click events a filter selects, per time bucket
+// and per value of each field stats are broken down by. Values are as
+// stored, so events without one are counted under "".
+type ClickAggregate struct {
+	Total     int
+	Buckets   map[time.Time]int
+	Countries map[string]int
+	Cities    map[string]int
+	OS        map[string]int
+	Browsers  map[string]int
+	Referrers map[string]int
+}
+
+func NewClickAggregate() *ClickAggregate {
+	return &ClickAggregate{
+		Buckets:   make(map[time.Time]int),
+		Countries: make(map[string]int),
+		Cities:    make(map[string]int),
+		OS:        make(map[string]int),
+		Browsers:  make(map[string]int),
+		Referrers: make(map[string]int),
+	}
+}
+
+type ClickEventPage struct {
+	Events     []ClickEvent `json:"events"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+type UrlStatsQuery struct {
+	Domain   string    `form:"domain"`
+	From     time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To       time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	Interval string    `form:"interval"`
+}
+
+type StatsBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int       `json:"clicks"`
+}
+
+// UrlStats combines the lifetime counters kept on Url with a breakdown of
+// the click events that fall inside the requested window.
+type UrlStats struct {
+	Key           string         `json:"short_url_key"`
+	TotalClicks   int            `json:"total_clicks"`
+	TotalDevice   map[string]int `json:"total_device"`
+	TotalLocation map[string]int `json:"total_location"`
+	From          time.Time      `json:"from"`
+	To            time.Time      `json:"to"`
+	Interval      string         `json:"interval"`
+	Clicks        int            `json:"clicks"`
+	Series        []StatsBucket  `json:"series"`
+	Countries     map[string]int `json:"countries"`
+	Cities        map[string]int `json:"cities"`
+	OS            map[string]int `json:"os"`
+	Browsers      map[string]int `json:"browsers"`
+	Referrers     map[string]int `json:"referrers"`
+}
+
+// BlockRule matches destination URLs. Type is domain (the host or any of
+// its subdomains), suffix (the host ends with Pattern) or regex (matched
+// against the whole URL). Action is block or flag.
+type BlockRule struct {
+	RuleID    primitive.ObjectID `json:"rule_id" bson:"_id"`
+	Type      string             `json:"type" bson:"type"`
+	Pattern   string             `json:"pattern" bson:"pattern"`
+	Action    string             `json:"action" bson:"action"`
+	Reason    string             `json:"reason,omitempty" bson:"reason,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type CreateBlockRuleReq struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+	Reason  string `json:"reason"`
+}
+
+// Domain is a custom host a user serves links from. It can only be used
+// once Verified, which requires a DNS TXT record containing the token.
+type Domain struct {
+	DomainID          primitive.ObjectID `json:"domain_id" bson:"_id"`
+	UserID            primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Host              string             `json:"host" bson:"host"`
+	VerificationToken string             `json:"verification_token" bson:"verification_token"`
+	Verified          bool               `json:"verified" bson:"verified"`
+	VerifiedAt        *time.Time         `json:"verified_at,omitempty" bson:"verified_at,omitempty"`
+	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type CreateDomainReq struct {
+	Host string `json:"host"`
+}
+
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+	RoleOwner  = "owner"
+)
+
+type Workspace struct {
+	WorkspaceID primitive.ObjectID `json:"workspace_id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"`
+	OwnerID     primitive.ObjectID `json:"owner_id" bson:"owner_id"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// WorkspaceMember is a user's role in a workspace. Invitations are members
+// with status "invited" that have no user yet; they are matched on email
+// and accepted with the invitation token until ExpiresAt.
+type WorkspaceMember struct {
+	MemberID        primitive.ObjectID  `json:"member_id" bson:"_id"`
+	WorkspaceID     primitive.ObjectID  `json:"workspace_id" bson:"workspace_id"`
+	UserID          *primitive.ObjectID `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Email           string              `json:"email" bson:"email"`
+	Role            string              `json:"role" bson:"role"`
+	Status          string              `json:"status" bson:"status"`
+	InviteTokenHash string              `json:"-" bson:"invite_token_hash,omitempty"`
+	InvitedBy       primitive.ObjectID  `json:"invited_by,omitempty" bson:"invited_by,omitempty"`
+	CreatedAt       time.Time           `json:"created_at" bson:"created_at"`
+	ExpiresAt       *time.Time          `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	JoinedAt        *time.Time          `json:"joined_at,omitempty" bson:"joined_at,omitempty"`
+}
+
+type CreateWorkspaceReq struct {
+	Name string `json:"name"`
+}
+
+type InviteMemberReq struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type InviteMemberRes struct {
+	Member WorkspaceMember `json:"member"`
+	Token  string          `json:"token"`
+}
+
+type AcceptInvitationReq struct {
+	Token string `json:"token"`
+}
+
+type UpdateMemberReq struct {
+	Role string `json:"role"`
+}
+
+const (
+	ScopeLinksRead  = "links:read"
+	ScopeLinksWrite = "links:write"
+	ScopeStatsRead  = "stats:read"
+)
+
+// ApiKeyPrefix starts every API key, so keys are easy to spot in logs and
+// can be told apart from JWTs.
+const ApiKeyPrefix = "sk_"
+
+// ApiKey is a personal access token. Only a hash of the key is stored; the
+// prefix is kept so users can tell their keys apart.
+type ApiKey struct {
+	KeyID      primitive.ObjectID `json:"key_id" bson:"_id"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	KeyHash    string             `json:"-" bson:"key_hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt *time.Time         `json:"last_used_at,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+}
+
+type CreateApiKeyReq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type CreateApiKeyRes struct {
+	ApiKey ApiKey `json:"api_key"`
+	Key    string `json:"key"`
 }
 
 type User struct {
-	UserID               primitive.ObjectID `bson:"_id"`
-	FullName             string             `json:"full_name"`
-	Email                string             `json:"email"`
-	Password             string             `json:"password"`
-	Created_at           time.Time          `json:"created_at"`
-	RefreshToken         string             `json:"refresh_token" bson:"refresh_token"`
-	RefreshTokenIssuedAT time.Time          `json:"refresh_token_issued_at" bson:"refresh_token_issued_at"`
+	UserID     primitive.ObjectID `bson:"_id"`
+	FullName   string             `json:"full_name"`
+	Email      string             `json:"email"`
+	Password   string             `json:"password"`
+	Created_at time.Time          `json:"created_at"`
+
+	EmailVerified   bool       `json:"email_verified" bson:"email_verified"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" bson:"email_verified_at,omitempty"`
 }
 
-type JwtCustomAccessClaims struct {
-	Name   string `json:"name"`
-	UserID string `json:"user_id"`
-	jwt.RegisteredClaims
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// UserToken is a single-use token mailed to a user, stored hashed.
+type UserToken struct {
+	TokenID   primitive.ObjectID `bson:"_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Purpose   string             `bson:"purpose"`
+	TokenHash string             `bson:"token_hash"`
+	CreatedAt time.Time          `bson:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty"`
 }
 
-type JwtCustomRefreshClaims struct {
-	UserID string `json:"user_id"`
+type VerifyEmailReq struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordReq struct {
+	Email string `json:"email"`
+	IP    string `json:"-"`
+}
+
+type ResetPasswordReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Session is one signed-in device. The refresh token is rotated on every
+// use; the hashes of rotated tokens are kept so a replayed one can be
+// recognised and the session revoked.
+type Session struct {
+	SessionID      primitive.ObjectID `json:"session_id" bson:"_id"`
+	UserID         primitive.ObjectID `json:"-" bson:"user_id"`
+	TokenHash      string             `json:"-" bson:"token_hash"`
+	PreviousHashes []string           `json:"-" bson:"previous_hashes"`
+	UserAgent      string             `json:"user_agent" bson:"user_agent"`
+	IP             string             `json:"ip" bson:"ip"`
+	CreatedAt      time.Time          `json:"created_at" bson:"created_at"`
+	LastUsedAt     time.Time          `json:"last_used_at" bson:"last_used_at"`
+	ExpiresAt      time.Time          `json:"expires_at" bson:"expires_at"`
+	RevokedAt      *time.Time         `json:"revoked_at,omitempty" bson:"revoked_at,omitempty"`
+	Current        bool               `json:"current" bson:"-"`
+}
+
+// SessionMeta describes the device a session is started or refreshed from.
+type SessionMeta struct {
+	UserAgent string
+	IP        string
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+const (
+	AuthCookie = "cookie"
+	AuthBearer = "bearer"
+	AuthApiKey = "api_key"
+)
+
+// Principal is who a request is made by. Scopes is only set for API keys;
+// sessions are not limited by scopes.
+type Principal struct {
+	UserID     string
+	Name       string
+	Scopes     []string
+	AuthMethod string
+	SessionID  string
+}
+
+type JwtCustomAccessClaims struct {
+	Name      string `json:"name"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ErrDuplicate is returned by repositories when an insert would break a
+// uniqueness rule, such as a taken email or short key.
+var ErrDuplicate = errors.New("duplicate key")
+
+// UserRepositoryInterface is implemented for MongoDB, SQLite and memory.
+// Inserts that would break a uniqueness rule (email, key per domain, verified
+// custom host, API key) fail with ErrDuplicate, atomically; lookups that find
+// nothing return mongo.ErrNoDocuments whatever the backend.
 type UserRepositoryInterface interface {
 	Signup(ctx context.Context, user *User) error
 	CheckUniqueEmail(ctx context.Context, email string) (int64, error)
 	GetUserByEmail(ctx context.Context, email string) (*User, error)
 	GetUserById(ctx context.Context, userID primitive.ObjectID) (*User, error)
-	UpdateRefreshTokenInDB(ctx context.Context, userID primitive.ObjectID, refreshToken any, at time.Time) error
 
-	CheckUniqueUrlKey(ctx context.Context, key string) (int64, error)
+	MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, at time.Time) error
+	UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error
+
+	InsertUserToken(ctx context.Context, token *UserToken) error
+	// UseUserToken marks the unused, unexpired token with tokenHash and
+	// purpose as used and returns it, so each token works only once.
+	UseUserToken(ctx context.Context, tokenHash string, purpose string, now time.Time) (*UserToken, error)
+	DeleteUserTokens(ctx context.Context, userID primitive.ObjectID, purpose string) error
+
+	InsertSession(ctx context.Context, session *Session) error
+	// GetSessionByTokenHash finds the session whose current or a rotated
+	// refresh token hashes to tokenHash.
+	GetSessionByTokenHash(ctx context.Context, tokenHash string) (*Session, error)
+	GetSessionsByUser(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]Session, error)
+	// RotateSession replaces the session's refresh token, provided it is
+	// still oldHash and the session is not revoked.
+	RotateSession(ctx context.Context, session *Session, oldHash string) error
+	RevokeSession(ctx context.Context, sessionID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error
+	RevokeSessionsByUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error
+
+	// Keys are unique per domain; the empty domain is the shared default
+	// host.
+	CheckUniqueUrlKey(ctx context.Context, domain string, key string) (int64, error)
 	InsertUrl(ctx context.Context, url *Url) error
-	GetAllURLs(ctx context.Context, userID primitive.ObjectID) (*[]Url, error)
-	UpdateUrlInfoInDB(ctx context.Context, key string, device string, location string) (*Url, error)
+	// InsertUrls stores every link or, if any of them clashes, none.
+	InsertUrls(ctx context.Context, urls []*Url) error
+	EachURL(ctx context.Context, userID primitive.ObjectID, fn func(*Url) error) error
+	// GetAllURLs returns at most query.Limit links, ordered by query.Sort
+	// and query.Order and starting after query.After.
+	GetAllURLs(ctx context.Context, userID primitive.ObjectID, query *ListUrlsQuery) ([]Url, error)
+	GetUrlByKey(ctx context.Context, domain string, key string) (*Url, error)
+	ExpireURLs(ctx context.Context, now time.Time) (int64, error)
+	GetUrlByID(ctx context.Context, urlID primitive.ObjectID) (*Url, error)
+	UpdateUrl(ctx context.Context, url *Url) error
+	DeleteUrl(ctx context.Context, urlID primitive.ObjectID, at time.Time) error
+	// TakeClick counts one click against a link's max_clicks, provided the
+	// limit has not been reached; otherwise it returns mongo.ErrNoDocuments.
+	TakeClick(ctx context.Context, urlID primitive.ObjectID) error
+	RecordClicks(ctx context.Context, clicks []*ClickEvent) error
+	// Several users may claim a host, each once; only one claim can be
+	// verified, so VerifyDomain fails with ErrDuplicate for the others.
+	InsertDomain(ctx context.Context, domain *Domain) error
+	GetDomainByID(ctx context.Context, domainID primitive.ObjectID) (*Domain, error)
+	// GetDomainByHost returns the verified claim on host.
+	GetDomainByHost(ctx context.Context, host string) (*Domain, error)
+	GetDomainsByUser(ctx context.Context, userID primitive.ObjectID) ([]Domain, error)
+	VerifyDomain(ctx context.Context, domainID primitive.ObjectID, at time.Time) error
+
+	InsertWorkspace(ctx context.Context, workspace *Workspace) error
+	GetWorkspaceByID(ctx context.Context, workspaceID primitive.ObjectID) (*Workspace, error)
+	GetWorkspacesByUser(ctx context.Context, userID primitive.ObjectID) ([]Workspace, error)
+	InsertWorkspaceMember(ctx context.Context, member *WorkspaceMember) error
+	GetWorkspaceMember(ctx context.Context, workspaceID primitive.ObjectID, userID primitive.ObjectID) (*WorkspaceMember, error)
+	GetWorkspaceMemberByID(ctx context.Context, memberID primitive.ObjectID) (*WorkspaceMember, error)
+	GetWorkspaceMemberByInviteToken(ctx context.Context, tokenHash string) (*WorkspaceMember, error)
+	GetWorkspaceMembers(ctx context.Context, workspaceID primitive.ObjectID) ([]WorkspaceMember, error)
+	UpdateWorkspaceMember(ctx context.Context, member *WorkspaceMember) error
+	DeleteWorkspaceMember(ctx context.Context, memberID primitive.ObjectID) error
+
+	InsertApiKey(ctx context.Context, key *ApiKey) error
+	GetApiKeyByHash(ctx context.Context, keyHash string) (*ApiKey, error)
+	GetApiKeysByUser(ctx context.Context, userID primitive.ObjectID) ([]ApiKey, error)
+	RevokeApiKey(ctx context.Context, keyID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error
+	TouchApiKey(ctx context.Context, keyID primitive.ObjectID, at time.Time) error
+
+	GetBlockRules(ctx context.Context) ([]BlockRule, error)
+	InsertBlockRule(ctx context.Context, rule *BlockRule) error
+	DeleteBlockRule(ctx context.Context, ruleID primitive.ObjectID) error
+
+	FindClickEvents(ctx context.Context, filter *ClickEventFilter) ([]ClickEvent, error)
+	// AggregateClicks counts matching events in the database. Buckets are
+	// bucket long and start at UTC multiples of it since the Unix epoch, so
+	// an hour or a day lines up with UTC hours or days.
+	AggregateClicks(ctx context.Context, filter *ClickEventFilter, bucket time.Duration) (*ClickAggregate, error)
 }
 
 type UserServiceInterface interface {
-	Signup(c context.Context, userReq *CreateUserReq) (*SignupLoginUserRes, error)
-	Login(c context.Context, loginReq *LoginUserReq) (*SignupLoginUserRes, error)
+	Signup(c context.Context, userReq *CreateUserReq, meta *SessionMeta) (*SignupLoginUserRes, error)
+	Login(c context.Context, loginReq *LoginUserReq, meta *SessionMeta) (*SignupLoginUserRes, error)
+
+	CreateURL(c context.Context, userID string, urlReq *CreateUrlReq) (*Url, error)
+	// CreateURLs validates every row before inserting any. Unless partial is
+	// set, a single invalid row means nothing is inserted.
+	CreateURLs(c context.Context, userID string, urlReqs []CreateUrlReq, partial bool) (*BulkUrlRes, error)
+	GetAllURLs(c context.Context, userID string, query *ListUrlsQuery) (*UrlPage, error)
+	ListClickEvents(c context.Context, userID string, domain string, key string, filter *ClickEventFilter) (*ClickEventPage, error)
+	ExportURLs(c context.Context, userID string, format string, w io.Writer) error
+	GetURLStats(c context.Context, userID string, key string, query *UrlStatsQuery) (*UrlStats, error)
+	UpdateURL(c context.Context, userID string, urlID string, req *UpdateUrlReq) (*Url, error)
+	SetURLDisabled(c context.Context, userID string, urlID string, disabled bool) (*Url, error)
+	DeleteURL(c context.Context, userID string, urlID string) error
+
+	RefreshAccessToken(c context.Context, refreshToken string, meta *SessionMeta) (*TokenPair, error)
+	Logout(c context.Context, userID string, sessionID string) error
+	SendEmailVerification(c context.Context, userID string) error
+	VerifyEmail(c context.Context, req *VerifyEmailReq) error
+	ForgotPassword(c context.Context, req *ForgotPasswordReq) error
+	ResetPassword(c context.Context, req *ResetPasswordReq) error
+	ListSessions(c context.Context, userID string, currentSessionID string) ([]Session, error)
+	RevokeSession(c context.Context, userID string, sessionID string) error
+	CheckSession(c context.Context, userID string, sessionID string) error
+	// RedirectURL returns the link together with the error when the link
+	// is expired (410), blocked (403), flagged and not yet confirmed (409)
+	// or needs a password (401), so the caller can render the right page.
+	RedirectURL(c context.Context, click *ClickEvent, password string, confirmed bool) (*Url, error)
+
+	AddDomain(c context.Context, userID string, req *CreateDomainReq) (*Domain, error)
+	ListDomains(c context.Context, userID string) ([]Domain, error)
+	VerifyDomain(c context.Context, userID string, domainID string) (*Domain, error)
+
+	CreateWorkspace(c context.Context, userID string, req *CreateWorkspaceReq) (*Workspace, error)
+	ListWorkspaces(c context.Context, userID string) ([]Workspace, error)
+	ListWorkspaceMembers(c context.Context, userID string, workspaceID string) ([]WorkspaceMember, error)
+	InviteMember(c context.Context, userID string, workspaceID string, req *InviteMemberReq) (*InviteMemberRes, error)
+	AcceptInvitation(c context.Context, userID string, req *AcceptInvitationReq) (*WorkspaceMember, error)
+	UpdateMember(c context.Context, userID string, workspaceID string, memberID string, req *UpdateMemberReq) (*WorkspaceMember, error)
+	RemoveMember(c context.Context, userID string, workspaceID string, memberID string) error
 
-	CreateURL(c context.Context, userID string, urlReq *CreateUrlReq) (string, error)
-	GetAllURLs(c context.Context, userID string) (*[]Url, error)
+	CreateApiKey(c context.Context, userID string, req *CreateApiKeyReq) (*CreateApiKeyRes, error)
+	ListApiKeys(c context.Context, userID string) ([]ApiKey, error)
+	RevokeApiKey(c context.Context, userID string, keyID string) error
+	AuthenticateApiKey(c context.Context, key string) (*ApiKey, error)
 
-	RefreshAccessToken(c context.Context, refreshToken string) (*string, error)
-	Logout(c context.Context, userID string) error
-	RedirectURL(c context.Context, key string, ip string, device string) (string, error)
+	ListBlockRules(c context.Context) ([]BlockRule, error)
+	AddBlockRule(c context.Context, req *CreateBlockRuleReq) (*BlockRule, error)
+	RemoveBlockRule(c context.Context, ruleID string) error
 }
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (u *userRepo) InsertApiKey(ctx context.Context, key *model.ApiKey) error {
+	_, err := u.db.Collection("api_key").InsertOne(ctx, key)
+	return duplicateError(err)
+}
+
+func (u *userRepo) GetApiKeyByHash(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	var key model.ApiKey
+	err := u.db.Collection("api_key").FindOne(ctx, bson.M{"key_hash": keyHash}).Decode(&key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+func (u *userRepo) GetApiKeysByUser(ctx context.Context, userID primitive.ObjectID) ([]model.ApiKey, error) {
+	cursor, err := u.db.Collection("api_key").Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	res := []model.ApiKey{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (u *userRepo) RevokeApiKey(ctx context.Context, keyID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"_id": keyID, "user_id": userID, "revoked_at": nil}
+
+	res, err := u.db.Collection("api_key").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) TouchApiKey(ctx context.Context, keyID primitive.ObjectID, at time.Time) error {
+	_, err := u.db.Collection("api_key").UpdateOne(ctx, bson.M{"_id": keyID}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
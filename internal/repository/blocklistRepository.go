@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (u *userRepo) GetBlockRules(ctx context.Context) ([]model.BlockRule, error) {
+	cursor, err := u.db.Collection("blocklist").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+
+	res := []model.BlockRule{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (u *userRepo) InsertBlockRule(ctx context.Context, rule *model.BlockRule) error {
+	_, err := u.db.Collection("blocklist").InsertOne(ctx, rule)
+	return err
+}
+
+func (u *userRepo) DeleteBlockRule(ctx context.Context, ruleID primitive.ObjectID) error {
+	res, err := u.db.Collection("blocklist").DeleteOne(ctx, bson.M{"_id": ruleID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (u *userRepo) RecordClicks(ctx context.Context, clicks []*model.ClickEvent) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	counters := make([]mongo.WriteModel, 0, len(clicks))
+	events := make([]interface{}, 0, len(clicks))
+	for _, click := range clicks {
+		inc := bson.M{
+			"device." + model.BreakdownField(click.OS):     1,
+			"location." + model.BreakdownField(click.City): 1,
+		}
+		if !click.Counted {
+			inc["no_of_clicks"] = 1
+		}
+
+		counters = append(counters, mongo.NewUpdateOneModel().
+			SetFilter(urlKeyFilter(click.Domain, click.Key)).
+			SetUpdate(bson.M{"$inc": inc}))
+		events = append(events, click)
+	}
+
+	// The events are the record of what happened, so they go in even if
+	// some counters can't be updated, and the other way round.
+	_, insertErr := u.db.Collection("click").InsertMany(ctx, events, options.InsertMany().SetOrdered(false))
+	_, countErr := u.db.Collection("url").BulkWrite(ctx, counters, options.BulkWrite().SetOrdered(false))
+
+	switch {
+	case insertErr != nil && countErr != nil:
+		return fmt.Errorf("storing click events: %w (and counting them failed: %v)", insertErr, countErr)
+	case insertErr != nil:
+		return fmt.Errorf("storing click events: %w", insertErr)
+	case countErr != nil:
+		return fmt.Errorf("counting clicks: %w", countErr)
+	}
+	return nil
+}
+
+func (u *userRepo) TakeClick(ctx context.Context, urlID primitive.ObjectID) error {
+	filter := bson.M{
+		"_id":        urlID,
+		"deleted_at": nil,
+		"$expr":      bson.M{"$lt": bson.A{"$no_of_clicks", "$max_clicks"}},
+	}
+
+	res, err := u.db.Collection("url").UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"no_of_clicks": 1}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) FindClickEvents(ctx context.Context, filter *model.ClickEventFilter) ([]model.ClickEvent, error) {
+	opts := options.Find().SetSort(bson.M{"_id": -1})
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+
+	cursor, err := u.db.Collection("click").Find(ctx, clickFilter(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []model.ClickEvent{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AggregateClicks groups in the database, one $facet per breakdown, after
+// a $match that the domain_key_at index serves.
+func (u *userRepo) AggregateClicks(ctx context.Context, filter *model.ClickEventFilter, bucket time.Duration) (*model.ClickAggregate, error) {
+	ms := bson.M{"$toLong": "$at"}
+	count := func(by interface{}) bson.A {
+		return bson.A{bson.M{"$group": bson.M{"_id": by, "n": bson.M{"$sum": 1}}}}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: clickFilter(filter)}},
+		{{Key: "$facet", Value: bson.M{
+			"buckets":   count(bson.M{"$subtract": bson.A{ms, bson.M{"$mod": bson.A{ms, bucket.Milliseconds()}}}}),
+			"countries": count("$country"),
+			"cities":    count("$city"),
+			"os":        count("$os"),
+			"browsers":  count("$browser"),
+			"referrers": count("$referrer"),
+		}}},
+	}
+
+	cursor, err := u.db.Collection("click").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		ID interface{} `bson:"_id"`
+		N  int         `bson:"n"`
+	}
+	var res []struct {
+		Buckets   []group `bson:"buckets"`
+		Countries []group `bson:"countries"`
+		Cities    []group `bson:"cities"`
+		OS        []group `bson:"os"`
+		Browsers  []group `bson:"browsers"`
+		Referrers []group `bson:"referrers"`
+	}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	agg := model.NewClickAggregate()
+	if len(res) == 0 {
+		return agg, nil
+	}
+
+	for _, g := range res[0].Buckets {
+		start, _ := g.ID.(int64)
+		agg.Buckets[time.UnixMilli(start).UTC()] += g.N
+		agg.Total += g.N
+	}
+
+	// Events stored before a field existed group under null.
+	for _, breakdown := range []struct {
+		groups []group
+		into   map[string]int
+	}{
+		{res[0].Countries, agg.Countries},
+		{res[0].Cities, agg.Cities},
+		{res[0].OS, agg.OS},
+		{res[0].Browsers, agg.Browsers},
+		{res[0].Referrers, agg.Referrers},
+	} {
+		for _, g := range breakdown.groups {
+			value, _ := g.ID.(string)
+			breakdown.into[value] += g.N
+		}
+	}
+
+	return agg, nil
+}
+
+func clickFilter(filter *model.ClickEventFilter) bson.M {
+	q := bson.M{"key": filter.Key}
+	if filter.Domain == "" {
+		q["domain"] = bson.M{"$in": bson.A{nil, ""}}
+	} else {
+		q["domain"] = filter.Domain
+	}
+
+	at := bson.M{}
+	if !filter.From.IsZero() {
+		at["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		at["$lt"] = filter.To
+	}
+	if len(at) > 0 {
+		q["at"] = at
+	}
+
+	if !filter.Before.IsZero() {
+		q["_id"] = bson.M{"$lt": filter.Before}
+	}
+
+	equal := map[string]string{
+		"country":      filter.Country,
+		"city":         filter.City,
+		"os":           filter.OS,
+		"browser":      filter.Browser,
+		"device_type":  filter.DeviceType,
+		"utm.source":   filter.UTMSource,
+		"utm.medium":   filter.UTMMedium,
+		"utm.campaign": filter.UTMCampaign,
+	}
+	for field, value := range equal {
+		if value != "" {
+			q[field] = value
+		}
+	}
+
+	if filter.Referrer != "" {
+		q["referrer"] = bson.M{"$regex": regexp.QuoteMeta(filter.Referrer), "$options": "i"}
+	}
+
+	return q
+}
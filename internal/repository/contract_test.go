@@ -0,0 +1,67 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/db"
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/internal/repository"
+	"example.com/url-shortener/internal/repository/repotest"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMemoryContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) model.UserRepositoryInterface {
+		return repository.NewMemoryRepository()
+	})
+}
+
+func TestSQLiteContract(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) model.UserRepositoryInterface {
+		t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "contract.db"))
+		sqlDB := db.NewSQLiteDatabase()
+		t.Cleanup(func() { sqlDB.Close() })
+
+		if err := repository.MigrateSQLite(context.Background(), sqlDB); err != nil {
+			t.Fatalf("MigrateSQLite: %v", err)
+		}
+		return repository.NewSQLiteRepository(sqlDB)
+	})
+}
+
+// TestMongoContract needs a server, so it only runs when MONGODB_TEST_URI
+// is set. Every subtest gets a database of its own, dropped afterwards.
+func TestMongoContract(t *testing.T) {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connecting to %s: %v", uri, err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	repotest.Run(t, func(t *testing.T) model.UserRepositoryInterface {
+		mongoDB := client.Database("contract_" + primitive.NewObjectID().Hex())
+		t.Cleanup(func() { mongoDB.Drop(context.Background()) })
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if err := repository.MigrateMongo(ctx, mongoDB); err != nil {
+			t.Fatalf("MigrateMongo: %v", err)
+		}
+		return repository.NewUserRepository(mongoDB)
+	})
+}
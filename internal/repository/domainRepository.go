@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (u *userRepo) InsertDomain(ctx context.Context, domain *model.Domain) error {
+	_, err := u.db.Collection("domain").InsertOne(ctx, domain)
+	return duplicateError(err)
+}
+
+func (u *userRepo) GetDomainByID(ctx context.Context, domainID primitive.ObjectID) (*model.Domain, error) {
+	var domain model.Domain
+	err := u.db.Collection("domain").FindOne(ctx, bson.M{"_id": domainID}).Decode(&domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain, nil
+}
+
+func (u *userRepo) GetDomainByHost(ctx context.Context, host string) (*model.Domain, error) {
+	var domain model.Domain
+	err := u.db.Collection("domain").FindOne(ctx, bson.M{"host": host, "verified": true}).Decode(&domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain, nil
+}
+
+func (u *userRepo) GetDomainsByUser(ctx context.Context, userID primitive.ObjectID) ([]model.Domain, error) {
+	cursor, err := u.db.Collection("domain").Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	res := []model.Domain{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (u *userRepo) VerifyDomain(ctx context.Context, domainID primitive.ObjectID, at time.Time) error {
+	res, err := u.db.Collection("domain").UpdateOne(ctx, bson.M{"_id": domainID}, bson.M{"$set": bson.M{"verified": true, "verified_at": at}})
+	if err != nil {
+		return duplicateError(err)
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
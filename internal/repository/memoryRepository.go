@@ -0,0 +1,1031 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// memoryRepo keeps everything in process memory, for tests, demos and
+// running the API without a database. It follows the Mongo repository's
+// semantics, including returning mongo.ErrNoDocuments when nothing
+// matches. Values are copied in and out, so callers never share state
+// with the store.
+type memoryRepo struct {
+	mu sync.RWMutex
+
+	users      map[primitive.ObjectID]*model.User
+	userTokens map[primitive.ObjectID]*model.UserToken
+	sessions   map[primitive.ObjectID]*model.Session
+	urls       map[primitive.ObjectID]*model.Url
+	clicks     []model.ClickEvent
+	domains    map[primitive.ObjectID]*model.Domain
+	workspaces map[primitive.ObjectID]*model.Workspace
+	members    map[primitive.ObjectID]*model.WorkspaceMember
+	apiKeys    map[primitive.ObjectID]*model.ApiKey
+	blockRules map[primitive.ObjectID]*model.BlockRule
+}
+
+func NewMemoryRepository() model.UserRepositoryInterface {
+	return &memoryRepo{
+		users:      make(map[primitive.ObjectID]*model.User),
+		userTokens: make(map[primitive.ObjectID]*model.UserToken),
+		sessions:   make(map[primitive.ObjectID]*model.Session),
+		urls:       make(map[primitive.ObjectID]*model.Url),
+		domains:    make(map[primitive.ObjectID]*model.Domain),
+		workspaces: make(map[primitive.ObjectID]*model.Workspace),
+		members:    make(map[primitive.ObjectID]*model.WorkspaceMember),
+		apiKeys:    make(map[primitive.ObjectID]*model.ApiKey),
+		blockRules: make(map[primitive.ObjectID]*model.BlockRule),
+	}
+}
+
+func (m *memoryRepo) Signup(ctx context.Context, user *model.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.UserID]; ok || m.userByEmail(user.Email) != nil {
+		return model.ErrDuplicate
+	}
+
+	c := *user
+	m.users[user.UserID] = &c
+	return nil
+}
+
+func (m *memoryRepo) userByEmail(email string) *model.User {
+	for _, user := range m.users {
+		if user.Email == email {
+			return user
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepo) CheckUniqueEmail(ctx context.Context, email string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.userByEmail(email) != nil {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m *memoryRepo) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user := m.userByEmail(email)
+	if user == nil {
+		return &model.User{}, mongo.ErrNoDocuments
+	}
+
+	c := *user
+	return &c, nil
+}
+
+func (m *memoryRepo) GetUserById(ctx context.Context, userID primitive.ObjectID) (*model.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return &model.User{}, mongo.ErrNoDocuments
+	}
+
+	c := *user
+	return &c, nil
+}
+
+func (m *memoryRepo) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	user.EmailVerified = true
+	user.EmailVerifiedAt = &at
+	return nil
+}
+
+func (m *memoryRepo) UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	user.Password = passwordHash
+	return nil
+}
+
+func (m *memoryRepo) InsertUserToken(ctx context.Context, token *model.UserToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.userTokens[token.TokenID]; ok {
+		return model.ErrDuplicate
+	}
+
+	c := *token
+	m.userTokens[token.TokenID] = &c
+	return nil
+}
+
+func (m *memoryRepo) UseUserToken(ctx context.Context, tokenHash string, purpose string, now time.Time) (*model.UserToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, token := range m.userTokens {
+		if token.TokenHash != tokenHash || token.Purpose != purpose || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+			continue
+		}
+
+		token.UsedAt = &now
+		c := *token
+		return &c, nil
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memoryRepo) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, token := range m.userTokens {
+		if token.UserID == userID && token.Purpose == purpose {
+			delete(m.userTokens, id)
+		}
+	}
+	return nil
+}
+
+func cloneSession(session *model.Session) *model.Session {
+	c := *session
+	c.PreviousHashes = append([]string{}, session.PreviousHashes...)
+	return &c
+}
+
+func (m *memoryRepo) InsertSession(ctx context.Context, session *model.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[session.SessionID]; ok {
+		return model.ErrDuplicate
+	}
+
+	m.sessions[session.SessionID] = cloneSession(session)
+	return nil
+}
+
+func (m *memoryRepo) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*model.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, session := range m.sessions {
+		if session.TokenHash == tokenHash {
+			return cloneSession(session), nil
+		}
+		for _, previous := range session.PreviousHashes {
+			if previous == tokenHash {
+				return cloneSession(session), nil
+			}
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memoryRepo) GetSessionsByUser(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]model.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := []model.Session{}
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			res = append(res, *cloneSession(session))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastUsedAt.After(res[j].LastUsedAt)
+	})
+
+	return res, nil
+}
+
+func (m *memoryRepo) RotateSession(ctx context.Context, session *model.Session, oldHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.sessions[session.SessionID]
+	if !ok || stored.TokenHash != oldHash || stored.RevokedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	stored.PreviousHashes = append(stored.PreviousHashes, oldHash)
+	stored.TokenHash = session.TokenHash
+	stored.UserAgent = session.UserAgent
+	stored.IP = session.IP
+	stored.LastUsedAt = session.LastUsedAt
+	stored.ExpiresAt = session.ExpiresAt
+	return nil
+}
+
+func (m *memoryRepo) RevokeSession(ctx context.Context, sessionID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.UserID != userID || session.RevokedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	session.RevokedAt = &at
+	return nil
+}
+
+func (m *memoryRepo) RevokeSessionsByUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil {
+			revokedAt := at
+			session.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+// urlByKey finds the link with key on domain, treating the empty domain as
+// the default host like urlKeyFilter does.
+func (m *memoryRepo) urlByKey(domain string, key string) *model.Url {
+	for _, url := range m.urls {
+		if url.ShortURLKey == key && url.Domain == domain {
+			return url
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepo) CheckUniqueUrlKey(ctx context.Context, domain string, key string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var count int64
+	for _, url := range m.urls {
+		if url.ShortURLKey == key && url.Domain == domain {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryRepo) InsertUrl(ctx context.Context, url *model.Url) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkNewUrl(url); err != nil {
+		return err
+	}
+
+	m.urls[url.UrlID] = url.Clone()
+	return nil
+}
+
+func (m *memoryRepo) checkNewUrl(url *model.Url) error {
+	if _, ok := m.urls[url.UrlID]; ok {
+		return model.ErrDuplicate
+	}
+	if m.urlByKey(url.Domain, url.ShortURLKey) != nil {
+		return model.ErrDuplicate
+	}
+	return nil
+}
+
+// InsertUrls stores every link or, if any of them clashes, none.
+func (m *memoryRepo) InsertUrls(ctx context.Context, urls []*model.Url) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		if err := m.checkNewUrl(url); err != nil {
+			return err
+		}
+		key := url.Domain + "/" + url.ShortURLKey
+		if seen[key] {
+			return model.ErrDuplicate
+		}
+		seen[key] = true
+	}
+
+	for _, url := range urls {
+		m.urls[url.UrlID] = url.Clone()
+	}
+	return nil
+}
+
+func (m *memoryRepo) EachURL(ctx context.Context, userID primitive.ObjectID, fn func(*model.Url) error) error {
+	m.mu.RLock()
+	var urls []*model.Url
+	for _, url := range m.urls {
+		if url.UserID == userID && url.WorkspaceID == nil && url.DeletedAt == nil {
+			urls = append(urls, url.Clone())
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(urls, func(i, j int) bool {
+		return bytes.Compare(urls[i].UrlID[:], urls[j].UrlID[:]) < 0
+	})
+
+	for _, url := range urls {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memoryRepo) GetAllURLs(ctx context.Context, userID primitive.ObjectID, query *model.ListUrlsQuery) ([]model.Url, error) {
+	var domain *regexp.Regexp
+	if query.Domain != "" {
+		domain = regexp.MustCompile("(?i)" + longURLDomainPattern(query.Domain))
+	}
+	now := time.Now()
+
+	m.mu.RLock()
+	var urls []model.Url
+	for _, url := range m.urls {
+		if matchesUrlList(url, userID, query, domain, now) {
+			urls = append(urls, *url.Clone())
+		}
+	}
+	m.mu.RUnlock()
+
+	desc := query.Order != "asc"
+	cmp := func(a *model.Url, b *model.Url) int {
+		var c int
+		switch query.Sort {
+		case "clicks":
+			c = compareInts(a.NoOfClicks, b.NoOfClicks)
+		case "label":
+			c = strings.Compare(a.Label, b.Label)
+		default:
+			c = compareTimes(a.CreatedAt, b.CreatedAt)
+		}
+		if c == 0 {
+			c = bytes.Compare(a.UrlID[:], b.UrlID[:])
+		}
+		if desc {
+			c = -c
+		}
+		return c
+	}
+
+	sort.Slice(urls, func(i, j int) bool {
+		return cmp(&urls[i], &urls[j]) < 0
+	})
+
+	if query.After != nil {
+		after := &model.Url{
+			UrlID:      query.After.ID,
+			CreatedAt:  query.After.CreatedAt,
+			NoOfClicks: query.After.Clicks,
+			Label:      query.After.Label,
+		}
+		i := sort.Search(len(urls), func(i int) bool {
+			return cmp(&urls[i], after) > 0
+		})
+		urls = urls[i:]
+	}
+
+	if query.Limit > 0 && len(urls) > query.Limit {
+		urls = urls[:query.Limit]
+	}
+
+	res := []model.Url{}
+	return append(res, urls...), nil
+}
+
+// matchesUrlList is urlListFilter for a single link.
+func matchesUrlList(url *model.Url, userID primitive.ObjectID, query *model.ListUrlsQuery, domain *regexp.Regexp, now time.Time) bool {
+	if url.DeletedAt != nil {
+		return false
+	}
+
+	if query.Workspace != nil {
+		if url.WorkspaceID == nil || *url.WorkspaceID != *query.Workspace {
+			return false
+		}
+	} else if url.UserID != userID || url.WorkspaceID != nil {
+		return false
+	}
+
+	if query.Label != "" && !strings.Contains(strings.ToLower(url.Label), strings.ToLower(query.Label)) {
+		return false
+	}
+
+	if domain != nil && !domain.MatchString(url.LongURL) {
+		return false
+	}
+
+	if !query.CreatedFrom.IsZero() && url.CreatedAt.Before(query.CreatedFrom) {
+		return false
+	}
+	if !query.CreatedTo.IsZero() && !url.CreatedAt.Before(query.CreatedTo) {
+		return false
+	}
+
+	switch query.Status {
+	case "enabled":
+		return !url.Disabled
+	case "disabled":
+		return url.Disabled
+	case "expired":
+		return url.IsExpired(now)
+	case "active":
+		return !url.Disabled && !url.IsExpired(now)
+	}
+
+	return true
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func (m *memoryRepo) GetUrlByKey(ctx context.Context, domain string, key string) (*model.Url, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	url := m.urlByKey(domain, key)
+	if url == nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return url.Clone(), nil
+}
+
+func (m *memoryRepo) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+	for _, url := range m.urls {
+		if !url.Expired && url.IsExpired(now) {
+			url.Expired = true
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *memoryRepo) GetUrlByID(ctx context.Context, urlID primitive.ObjectID) (*model.Url, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	url, ok := m.urls[urlID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return url.Clone(), nil
+}
+
+// UpdateUrl saves the editable fields of url, leaving click counters alone
+// like the Mongo repository does.
+func (m *memoryRepo) UpdateUrl(ctx context.Context, url *model.Url) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.urls[url.UrlID]
+	if !ok || stored.DeletedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	c := url.Clone()
+	stored.Label = c.Label
+	stored.LongURL = c.LongURL
+	stored.RedirectType = c.RedirectType
+	stored.ExpiresAt = c.ExpiresAt
+	stored.MaxClicks = c.MaxClicks
+	stored.FallbackURL = c.FallbackURL
+	stored.Expired = c.Expired
+	stored.Protected = c.Protected
+	stored.PasswordHash = c.PasswordHash
+	stored.Disabled = c.Disabled
+	stored.UpdatedAt = c.UpdatedAt
+	return nil
+}
+
+func (m *memoryRepo) DeleteUrl(ctx context.Context, urlID primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url, ok := m.urls[urlID]
+	if !ok || url.DeletedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	url.DeletedAt = &at
+	return nil
+}
+
+func (m *memoryRepo) RecordClicks(ctx context.Context, clicks []*model.ClickEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, click := range clicks {
+		if url := m.urlByKey(click.Domain, click.Key); url != nil {
+			if !click.Counted {
+				url.NoOfClicks++
+			}
+			if url.Device == nil {
+				url.Device = make(map[string]int)
+			}
+			if url.Location == nil {
+				url.Location = make(map[string]int)
+			}
+			url.Device[click.OS]++
+			url.Location[click.City]++
+		}
+
+		m.clicks = append(m.clicks, *click)
+	}
+
+	return nil
+}
+
+func (m *memoryRepo) TakeClick(ctx context.Context, urlID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	url, ok := m.urls[urlID]
+	if !ok || url.DeletedAt != nil || url.NoOfClicks >= url.MaxClicks {
+		return mongo.ErrNoDocuments
+	}
+
+	url.NoOfClicks++
+	return nil
+}
+
+func (m *memoryRepo) FindClickEvents(ctx context.Context, filter *model.ClickEventFilter) ([]model.ClickEvent, error) {
+	m.mu.RLock()
+	res := []model.ClickEvent{}
+	for _, event := range m.clicks {
+		if matchesClick(&event, filter) {
+			res = append(res, event)
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].ID[:], res[j].ID[:]) > 0
+	})
+
+	if filter.Limit > 0 && len(res) > filter.Limit {
+		res = res[:filter.Limit]
+	}
+
+	return res, nil
+}
+
+func (m *memoryRepo) AggregateClicks(ctx context.Context, filter *model.ClickEventFilter, bucket time.Duration) (*model.ClickAggregate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	agg := model.NewClickAggregate()
+	for _, event := range m.clicks {
+		if !matchesClick(&event, filter) {
+			continue
+		}
+
+		ms := event.At.UnixMilli()
+		agg.Buckets[time.UnixMilli(ms-ms%bucket.Milliseconds()).UTC()]++
+		agg.Total++
+		agg.Countries[event.Country]++
+		agg.Cities[event.City]++
+		agg.OS[event.OS]++
+		agg.Browsers[event.Browser]++
+		agg.Referrers[event.Referrer]++
+	}
+
+	return agg, nil
+}
+
+// matchesClick is clickFilter for a single event.
+func matchesClick(event *model.ClickEvent, filter *model.ClickEventFilter) bool {
+	if event.Key != filter.Key || event.Domain != filter.Domain {
+		return false
+	}
+
+	if !filter.From.IsZero() && event.At.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !event.At.Before(filter.To) {
+		return false
+	}
+
+	if !filter.Before.IsZero() && bytes.Compare(event.ID[:], filter.Before[:]) >= 0 {
+		return false
+	}
+
+	equal := [][2]string{
+		{filter.Country, event.Country},
+		{filter.City, event.City},
+		{filter.OS, event.OS},
+		{filter.Browser, event.Browser},
+		{filter.DeviceType, event.DeviceType},
+		{filter.UTMSource, event.UTM.Source},
+		{filter.UTMMedium, event.UTM.Medium},
+		{filter.UTMCampaign, event.UTM.Campaign},
+	}
+	for _, pair := range equal {
+		if pair[0] != "" && pair[0] != pair[1] {
+			return false
+		}
+	}
+
+	if filter.Referrer != "" && !strings.Contains(strings.ToLower(event.Referrer), strings.ToLower(filter.Referrer)) {
+		return false
+	}
+
+	return true
+}
+
+func (m *memoryRepo) InsertDomain(ctx context.Context, domain *model.Domain) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, d := range m.domains {
+		if d.DomainID == domain.DomainID || (d.UserID == domain.UserID && d.Host == domain.Host) {
+			return model.ErrDuplicate
+		}
+	}
+
+	c := *domain
+	m.domains[domain.DomainID] = &c
+	return nil
+}
+
+func (m *memoryRepo) GetDomainByID(ctx context.Context, domainID primitive.ObjectID) (*model.Domain, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	domain, ok := m.domains[domainID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	c := *domain
+	return &c, nil
+}
+
+func (m *memoryRepo) GetDomainByHost(ctx context.Context, host string) (*model.Domain, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, domain := range m.domains {
+		if domain.Host == host && domain.Verified {
+			c := *domain
+			return &c, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memoryRepo) GetDomainsByUser(ctx context.Context, userID primitive.ObjectID) ([]model.Domain, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := []model.Domain{}
+	for _, domain := range m.domains {
+		if domain.UserID == userID {
+			res = append(res, *domain)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].DomainID[:], res[j].DomainID[:]) < 0
+	})
+
+	return res, nil
+}
+
+func (m *memoryRepo) VerifyDomain(ctx context.Context, domainID primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	domain, ok := m.domains[domainID]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	for _, d := range m.domains {
+		if d.Host == domain.Host && d.Verified && d.DomainID != domainID {
+			return model.ErrDuplicate
+		}
+	}
+
+	domain.Verified = true
+	domain.VerifiedAt = &at
+	return nil
+}
+
+func (m *memoryRepo) InsertWorkspace(ctx context.Context, workspace *model.Workspace) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.workspaces[workspace.WorkspaceID]; ok {
+		return model.ErrDuplicate
+	}
+
+	c := *workspace
+	m.workspaces[workspace.WorkspaceID] = &c
+	return nil
+}
+
+func (m *memoryRepo) GetWorkspaceByID(ctx context.Context, workspaceID primitive.ObjectID) (*model.Workspace, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	workspace, ok := m.workspaces[workspaceID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	c := *workspace
+	return &c, nil
+}
+
+func (m *memoryRepo) GetWorkspacesByUser(ctx context.Context, userID primitive.ObjectID) ([]model.Workspace, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := []model.Workspace{}
+	for _, member := range m.members {
+		if member.UserID == nil || *member.UserID != userID || member.Status != "active" {
+			continue
+		}
+		if workspace, ok := m.workspaces[member.WorkspaceID]; ok {
+			res = append(res, *workspace)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].WorkspaceID[:], res[j].WorkspaceID[:]) < 0
+	})
+
+	return res, nil
+}
+
+func cloneMember(member *model.WorkspaceMember) *model.WorkspaceMember {
+	c := *member
+	if member.UserID != nil {
+		id := *member.UserID
+		c.UserID = &id
+	}
+	if member.JoinedAt != nil {
+		at := *member.JoinedAt
+		c.JoinedAt = &at
+	}
+	return &c
+}
+
+func (m *memoryRepo) InsertWorkspaceMember(ctx context.Context, member *model.WorkspaceMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.members[member.MemberID]; ok {
+		return model.ErrDuplicate
+	}
+
+	m.members[member.MemberID] = cloneMember(member)
+	return nil
+}
+
+func (m *memoryRepo) findMember(match func(*model.WorkspaceMember) bool) (*model.WorkspaceMember, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, member := range m.members {
+		if match(member) {
+			return cloneMember(member), nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memoryRepo) GetWorkspaceMember(ctx context.Context, workspaceID primitive.ObjectID, userID primitive.ObjectID) (*model.WorkspaceMember, error) {
+	return m.findMember(func(member *model.WorkspaceMember) bool {
+		return member.WorkspaceID == workspaceID && member.UserID != nil && *member.UserID == userID && member.Status == "active"
+	})
+}
+
+func (m *memoryRepo) GetWorkspaceMemberByID(ctx context.Context, memberID primitive.ObjectID) (*model.WorkspaceMember, error) {
+	return m.findMember(func(member *model.WorkspaceMember) bool {
+		return member.MemberID == memberID
+	})
+}
+
+func (m *memoryRepo) GetWorkspaceMemberByInviteToken(ctx context.Context, tokenHash string) (*model.WorkspaceMember, error) {
+	return m.findMember(func(member *model.WorkspaceMember) bool {
+		return member.InviteTokenHash == tokenHash && member.Status == "invited"
+	})
+}
+
+func (m *memoryRepo) GetWorkspaceMembers(ctx context.Context, workspaceID primitive.ObjectID) ([]model.WorkspaceMember, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := []model.WorkspaceMember{}
+	for _, member := range m.members {
+		if member.WorkspaceID == workspaceID {
+			res = append(res, *cloneMember(member))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].MemberID[:], res[j].MemberID[:]) < 0
+	})
+
+	return res, nil
+}
+
+func (m *memoryRepo) UpdateWorkspaceMember(ctx context.Context, member *model.WorkspaceMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.members[member.MemberID]; !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	m.members[member.MemberID] = cloneMember(member)
+	return nil
+}
+
+func (m *memoryRepo) DeleteWorkspaceMember(ctx context.Context, memberID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.members[memberID]; !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	delete(m.members, memberID)
+	return nil
+}
+
+func cloneApiKey(key *model.ApiKey) *model.ApiKey {
+	c := *key
+	c.Scopes = append([]string(nil), key.Scopes...)
+	return &c
+}
+
+func (m *memoryRepo) InsertApiKey(ctx context.Context, key *model.ApiKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.KeyID == key.KeyID || k.KeyHash == key.KeyHash {
+			return model.ErrDuplicate
+		}
+	}
+
+	m.apiKeys[key.KeyID] = cloneApiKey(key)
+	return nil
+}
+
+func (m *memoryRepo) GetApiKeyByHash(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.apiKeys {
+		if key.KeyHash == keyHash {
+			return cloneApiKey(key), nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+}
+
+func (m *memoryRepo) GetApiKeysByUser(ctx context.Context, userID primitive.ObjectID) ([]model.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := []model.ApiKey{}
+	for _, key := range m.apiKeys {
+		if key.UserID == userID {
+			res = append(res, *cloneApiKey(key))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].KeyID[:], res[j].KeyID[:]) < 0
+	})
+
+	return res, nil
+}
+
+func (m *memoryRepo) RevokeApiKey(ctx context.Context, keyID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.apiKeys[keyID]
+	if !ok || key.UserID != userID || key.RevokedAt != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	key.RevokedAt = &at
+	return nil
+}
+
+func (m *memoryRepo) TouchApiKey(ctx context.Context, keyID primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.apiKeys[keyID]; ok {
+		key.LastUsedAt = &at
+	}
+	return nil
+}
+
+func (m *memoryRepo) GetBlockRules(ctx context.Context) ([]model.BlockRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := []model.BlockRule{}
+	for _, rule := range m.blockRules {
+		res = append(res, *rule)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].RuleID[:], res[j].RuleID[:]) < 0
+	})
+
+	return res, nil
+}
+
+func (m *memoryRepo) InsertBlockRule(ctx context.Context, rule *model.BlockRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.blockRules[rule.RuleID]; ok {
+		return model.ErrDuplicate
+	}
+
+	c := *rule
+	m.blockRules[rule.RuleID] = &c
+	return nil
+}
+
+func (m *memoryRepo) DeleteBlockRule(ctx context.Context, ruleID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.blockRules[ruleID]; !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	delete(m.blockRules, ruleID)
+	return nil
+}
@@ -0,0 +1,327 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoMigration changes the database from one version to the next. Mongo
+// can't build indexes inside a transaction, so a crash between up and
+// recording the version runs up again next time: it has to be safe to
+// repeat. A nil down means there is nothing to undo.
+type mongoMigration struct {
+	version int
+	name    string
+	up      func(ctx context.Context, db *mongo.Database) error
+	down    func(ctx context.Context, db *mongo.Database) error
+}
+
+// mongoMigrations are applied in order and never edited once released;
+// changes go in a new entry.
+var mongoMigrations = []mongoMigration{
+	{
+		1, "unique indexes",
+		// These are what make inserts reject duplicates atomically; the
+		// services' own checks only exist to give a friendly error early.
+		// Building one fails if existing data already breaks it, and the
+		// duplicates have to be cleaned up by hand first.
+		createIndexes(map[string][]mongo.IndexModel{
+			"user": {
+				{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetName("email_unique").SetUnique(true)},
+			},
+			// Links on the default domain have no domain field, which indexes
+			// as null, so they share one keyspace. The index also serves
+			// lookups by short_url_key.
+			"url": {
+				{Keys: bson.D{{Key: "domain", Value: 1}, {Key: "short_url_key", Value: 1}}, Options: options.Index().SetName("domain_short_url_key_unique").SetUnique(true)},
+			},
+			"domain": {
+				{Keys: bson.D{{Key: "host", Value: 1}}, Options: options.Index().SetName("host_unique").SetUnique(true)},
+			},
+			"api_key": {
+				{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetName("key_hash_unique").SetUnique(true)},
+			},
+		}),
+		dropIndexes(map[string][]string{
+			"user":    {"email_unique"},
+			"url":     {"domain_short_url_key_unique"},
+			"domain":  {"host_unique"},
+			"api_key": {"key_hash_unique"},
+		}),
+	},
+	{
+		2, "lookup indexes",
+		createIndexes(map[string][]mongo.IndexModel{
+			"url": {
+				{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("user_id_created_at")},
+				{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "created_at", Value: -1}}, Options: options.Index().SetName("workspace_id_created_at")},
+			},
+			"click": {
+				{Keys: bson.D{{Key: "domain", Value: 1}, {Key: "key", Value: 1}, {Key: "at", Value: 1}}, Options: options.Index().SetName("domain_key_at")},
+			},
+			"session": {
+				{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetName("user_id")},
+				{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetName("token_hash")},
+				{Keys: bson.D{{Key: "previous_hashes", Value: 1}}, Options: options.Index().SetName("previous_hashes")},
+			},
+			"user_token": {
+				{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetName("token_hash")},
+				{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}, Options: options.Index().SetName("user_id_purpose")},
+			},
+			"domain": {
+				{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetName("user_id")},
+			},
+			"api_key": {
+				{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetName("user_id")},
+			},
+			"workspace_member": {
+				{Keys: bson.D{{Key: "workspace_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetName("workspace_id_user_id")},
+				{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetName("user_id")},
+				{Keys: bson.D{{Key: "invite_token_hash", Value: 1}}, Options: options.Index().SetName("invite_token_hash").SetSparse(true)},
+			},
+		}),
+		dropIndexes(map[string][]string{
+			"url":              {"user_id_created_at", "workspace_id_created_at"},
+			"click":            {"domain_key_at"},
+			"session":          {"user_id", "token_hash", "previous_hashes"},
+			"user_token":       {"token_hash", "user_id_purpose"},
+			"domain":           {"user_id"},
+			"api_key":          {"user_id"},
+			"workspace_member": {"user_id", "workspace_id_user_id", "invite_token_hash"},
+		}),
+	},
+	{
+		3, "backfill url and user fields",
+		// Links and users stored before these fields existed decode fine,
+		// but don't match queries on them.
+		func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("url").UpdateMany(ctx,
+				bson.M{"$or": bson.A{bson.M{"redirect_type": nil}, bson.M{"redirect_type": 0}}},
+				bson.M{"$set": bson.M{"redirect_type": http.StatusFound}})
+			if err != nil {
+				return err
+			}
+
+			for _, field := range []string{"expired", "protected", "disabled"} {
+				_, err := db.Collection("url").UpdateMany(ctx, bson.M{field: bson.M{"$exists": false}}, bson.M{"$set": bson.M{field: false}})
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err = db.Collection("user").UpdateMany(ctx, bson.M{"email_verified": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"email_verified": false}})
+			return err
+		},
+		nil,
+	},
+	{
+		4, "unique verified domain hosts",
+		// Anyone may claim a host; it belongs to whoever verifies it first.
+		func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(map[string][]string{"domain": {"host_unique"}})(ctx, db); err != nil {
+				return err
+			}
+			return createIndexes(map[string][]mongo.IndexModel{
+				"domain": {
+					{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "host", Value: 1}}, Options: options.Index().SetName("user_id_host_unique").SetUnique(true)},
+					{Keys: bson.D{{Key: "host", Value: 1}}, Options: options.Index().SetName("verified_host_unique").SetUnique(true).
+						SetPartialFilterExpression(bson.M{"verified": true})},
+				},
+			})(ctx, db)
+		},
+		func(ctx context.Context, db *mongo.Database) error {
+			if err := dropIndexes(map[string][]string{"domain": {"user_id_host_unique", "verified_host_unique"}})(ctx, db); err != nil {
+				return err
+			}
+			return createIndexes(map[string][]mongo.IndexModel{
+				"domain": {
+					{Keys: bson.D{{Key: "host", Value: 1}}, Options: options.Index().SetName("host_unique").SetUnique(true)},
+				},
+			})(ctx, db)
+		},
+	},
+}
+
+func createIndexes(indexes map[string][]mongo.IndexModel) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for collection, models := range indexes {
+			if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+				return fmt.Errorf("creating indexes on %s: %w", collection, err)
+			}
+		}
+		return nil
+	}
+}
+
+func dropIndexes(names map[string][]string) func(context.Context, *mongo.Database) error {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for collection, indexes := range names {
+			for _, name := range indexes {
+				_, err := db.Collection(collection).Indexes().DropOne(ctx, name)
+				if err != nil && !isIndexNotFound(err) {
+					return fmt.Errorf("dropping index %s on %s: %w", name, collection, err)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// isIndexNotFound lets a down migration finish when the index, or its whole
+// collection, is already gone.
+func isIndexNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound")
+}
+
+// migrationLockLease is how long a crashed process keeps others from
+// migrating. It is renewed before every migration, so it has to outlast
+// the slowest single one.
+const migrationLockLease = 10 * time.Minute
+
+type mongoMigrationRecord struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// MigrateMongo applies every migration that hasn't been yet. Instances
+// starting together take turns; the ones that wait find nothing left to do.
+func MigrateMongo(ctx context.Context, db *mongo.Database) error {
+	return withMigrationLock(ctx, db, func(renew func() error) error {
+		applied, err := appliedMongoMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range mongoMigrations {
+			if applied[m.version] {
+				continue
+			}
+
+			if err := renew(); err != nil {
+				return err
+			}
+
+			if err := m.up(ctx, db); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+
+			record := mongoMigrationRecord{Version: m.version, Name: m.name, AppliedAt: time.Now()}
+			if _, err := db.Collection("migration").InsertOne(ctx, record); err != nil {
+				return fmt.Errorf("recording migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// MigrateMongoDown reverts the last steps applied migrations, newest first.
+func MigrateMongoDown(ctx context.Context, db *mongo.Database, steps int) error {
+	return withMigrationLock(ctx, db, func(renew func() error) error {
+		applied, err := appliedMongoMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(mongoMigrations) - 1; i >= 0 && steps > 0; i-- {
+			m := mongoMigrations[i]
+			if !applied[m.version] {
+				continue
+			}
+
+			if err := renew(); err != nil {
+				return err
+			}
+
+			if m.down != nil {
+				if err := m.down(ctx, db); err != nil {
+					return fmt.Errorf("reverting migration %d (%s): %w", m.version, m.name, err)
+				}
+			}
+
+			if _, err := db.Collection("migration").DeleteOne(ctx, bson.M{"_id": m.version}); err != nil {
+				return fmt.Errorf("unrecording migration %d (%s): %w", m.version, m.name, err)
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+func appliedMongoMigrations(ctx context.Context, db *mongo.Database) (map[int]bool, error) {
+	cursor, err := db.Collection("migration").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []mongoMigrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]bool, len(records))
+	for _, record := range records {
+		applied[record.Version] = true
+	}
+
+	return applied, nil
+}
+
+// withMigrationLock runs fn holding the lease on the single lock document,
+// waiting for whoever holds it now. fn calls renew to extend the lease.
+func withMigrationLock(ctx context.Context, db *mongo.Database, fn func(renew func() error) error) error {
+	locks := db.Collection("migration_lock")
+	owner := primitive.NewObjectID().Hex()
+
+	// Taking the lock upserts the document unless someone else holds an
+	// unexpired lease, in which case the upsert clashes on _id.
+	take := func() error {
+		now := time.Now()
+		filter := bson.M{"_id": "migrate", "$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lte": now}},
+		}}
+		update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(migrationLockLease)}}
+		_, err := locks.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		return err
+	}
+
+	for {
+		err := take()
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for migration lock: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+
+	// If releasing fails the lease runs out on its own.
+	defer locks.DeleteOne(context.Background(), bson.M{"_id": "migrate", "owner": owner})
+
+	renew := func() error {
+		if err := take(); err != nil {
+			return fmt.Errorf("renewing migration lock: %w", err)
+		}
+		return nil
+	}
+
+	return fn(renew)
+}
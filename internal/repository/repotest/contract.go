@@ -0,0 +1,725 @@
+// Package repotest is the contract every model.UserRepositoryInterface
+// implementation must honour. Call Run from an implementation's tests:
+//
+//	func TestContract(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) model.UserRepositoryInterface {
+//			return repository.NewMemoryRepository()
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Run runs the contract against fresh repositories from newRepo, one per
+// subtest.
+func Run(t *testing.T, newRepo func(t *testing.T) model.UserRepositoryInterface) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo model.UserRepositoryInterface)
+	}{
+		{"Users", testUsers},
+		{"UserTokens", testUserTokens},
+		{"Sessions", testSessions},
+		{"UrlKeys", testUrlKeys},
+		{"UrlUpdates", testUrlUpdates},
+		{"UrlListing", testUrlListing},
+		{"ExpireURLs", testExpireURLs},
+		{"Clicks", testClicks},
+		{"ClickLimit", testClickLimit},
+		{"ClickBreakdownNames", testClickBreakdownNames},
+		{"Domains", testDomains},
+		{"Workspaces", testWorkspaces},
+		{"ApiKeys", testApiKeys},
+		{"BlockRules", testBlockRules},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+// now is truncated to milliseconds, the precision Mongo stores.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func wantNotFound(t *testing.T, err error, what string) {
+	t.Helper()
+	if err != mongo.ErrNoDocuments {
+		t.Fatalf("%s: got error %v, want mongo.ErrNoDocuments", what, err)
+	}
+}
+
+func must(t *testing.T, err error, what string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", what, err)
+	}
+}
+
+func newUser(email string) *model.User {
+	return &model.User{
+		UserID:     primitive.NewObjectID(),
+		FullName:   "Test User",
+		Email:      email,
+		Password:   "hash",
+		Created_at: now(),
+	}
+}
+
+func newUrl(userID primitive.ObjectID, domain string, key string) *model.Url {
+	return &model.Url{
+		UrlID:       primitive.NewObjectID(),
+		UserID:      userID,
+		Label:       key,
+		LongURL:     "https://example.com/" + key,
+		ShortURLKey: key,
+		Domain:      domain,
+		Device:      map[string]int{},
+		Location:    map[string]int{},
+		CreatedAt:   now(),
+	}
+}
+
+func testUsers(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+
+	user := newUser("a@example.com")
+	must(t, repo.Signup(ctx, user), "Signup")
+
+	if err := repo.Signup(ctx, newUser("a@example.com")); !errors.Is(err, model.ErrDuplicate) {
+		t.Fatalf("Signup with a taken email: got %v, want model.ErrDuplicate", err)
+	}
+
+	count, err := repo.CheckUniqueEmail(ctx, "a@example.com")
+	must(t, err, "CheckUniqueEmail")
+	if count != 1 {
+		t.Fatalf("CheckUniqueEmail = %d, want 1", count)
+	}
+
+	got, err := repo.GetUserByEmail(ctx, "a@example.com")
+	must(t, err, "GetUserByEmail")
+	if got.UserID != user.UserID {
+		t.Fatalf("GetUserByEmail returned user %s, want %s", got.UserID.Hex(), user.UserID.Hex())
+	}
+
+	_, err = repo.GetUserByEmail(ctx, "nobody@example.com")
+	wantNotFound(t, err, "GetUserByEmail of unknown email")
+
+	_, err = repo.GetUserById(ctx, primitive.NewObjectID())
+	wantNotFound(t, err, "GetUserById of unknown id")
+
+	at := now()
+	must(t, repo.MarkEmailVerified(ctx, user.UserID, at), "MarkEmailVerified")
+	must(t, repo.UpdatePassword(ctx, user.UserID, "new-hash"), "UpdatePassword")
+
+	got, err = repo.GetUserById(ctx, user.UserID)
+	must(t, err, "GetUserById")
+	if !got.EmailVerified || got.EmailVerifiedAt == nil || !got.EmailVerifiedAt.Equal(at) {
+		t.Fatalf("email not marked verified: %+v", got)
+	}
+	if got.Password != "new-hash" {
+		t.Fatalf("password = %q, want new-hash", got.Password)
+	}
+
+	wantNotFound(t, repo.UpdatePassword(ctx, primitive.NewObjectID(), "x"), "UpdatePassword of unknown user")
+}
+
+func testUserTokens(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	at := now()
+
+	token := &model.UserToken{
+		TokenID:   primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   model.TokenResetPassword,
+		TokenHash: "hash-1",
+		CreatedAt: at,
+		ExpiresAt: at.Add(time.Hour),
+	}
+	must(t, repo.InsertUserToken(ctx, token), "InsertUserToken")
+
+	_, err := repo.UseUserToken(ctx, "hash-1", model.TokenVerifyEmail, at)
+	wantNotFound(t, err, "UseUserToken with the wrong purpose")
+
+	_, err = repo.UseUserToken(ctx, "hash-1", model.TokenResetPassword, at.Add(2*time.Hour))
+	wantNotFound(t, err, "UseUserToken after expiry")
+
+	used, err := repo.UseUserToken(ctx, "hash-1", model.TokenResetPassword, at)
+	must(t, err, "UseUserToken")
+	if used.UserID != userID || used.UsedAt == nil {
+		t.Fatalf("UseUserToken returned %+v", used)
+	}
+
+	_, err = repo.UseUserToken(ctx, "hash-1", model.TokenResetPassword, at)
+	wantNotFound(t, err, "UseUserToken twice")
+
+	token.TokenID, token.TokenHash = primitive.NewObjectID(), "hash-2"
+	must(t, repo.InsertUserToken(ctx, token), "InsertUserToken")
+	must(t, repo.DeleteUserTokens(ctx, userID, model.TokenResetPassword), "DeleteUserTokens")
+
+	_, err = repo.UseUserToken(ctx, "hash-2", model.TokenResetPassword, at)
+	wantNotFound(t, err, "UseUserToken after DeleteUserTokens")
+}
+
+func testSessions(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	at := now()
+
+	session := &model.Session{
+		SessionID:      primitive.NewObjectID(),
+		UserID:         userID,
+		TokenHash:      "t1",
+		PreviousHashes: []string{},
+		CreatedAt:      at,
+		LastUsedAt:     at,
+		ExpiresAt:      at.Add(time.Hour),
+	}
+	must(t, repo.InsertSession(ctx, session), "InsertSession")
+
+	session.TokenHash = "t2"
+	must(t, repo.RotateSession(ctx, session, "t1"), "RotateSession")
+	wantNotFound(t, repo.RotateSession(ctx, session, "t1"), "RotateSession from a rotated token")
+
+	for _, hash := range []string{"t1", "t2"} {
+		got, err := repo.GetSessionByTokenHash(ctx, hash)
+		must(t, err, "GetSessionByTokenHash "+hash)
+		if got.SessionID != session.SessionID || got.TokenHash != "t2" {
+			t.Fatalf("GetSessionByTokenHash(%s) = %+v", hash, got)
+		}
+	}
+
+	other := *session
+	other.SessionID, other.TokenHash, other.PreviousHashes = primitive.NewObjectID(), "t3", []string{}
+	must(t, repo.InsertSession(ctx, &other), "InsertSession")
+
+	sessions, err := repo.GetSessionsByUser(ctx, userID, at)
+	must(t, err, "GetSessionsByUser")
+	if len(sessions) != 2 {
+		t.Fatalf("GetSessionsByUser returned %d sessions, want 2", len(sessions))
+	}
+
+	wantNotFound(t, repo.RevokeSession(ctx, session.SessionID, primitive.NewObjectID(), at), "RevokeSession of another user's session")
+	must(t, repo.RevokeSession(ctx, session.SessionID, userID, at), "RevokeSession")
+	wantNotFound(t, repo.RevokeSession(ctx, session.SessionID, userID, at), "RevokeSession twice")
+
+	session.TokenHash = "t4"
+	wantNotFound(t, repo.RotateSession(ctx, session, "t2"), "RotateSession of a revoked session")
+
+	must(t, repo.RevokeSessionsByUser(ctx, userID, at), "RevokeSessionsByUser")
+	sessions, err = repo.GetSessionsByUser(ctx, userID, at)
+	must(t, err, "GetSessionsByUser")
+	if len(sessions) != 0 {
+		t.Fatalf("GetSessionsByUser after RevokeSessionsByUser returned %d sessions", len(sessions))
+	}
+}
+
+func testUrlKeys(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	must(t, repo.InsertUrl(ctx, newUrl(userID, "", "abc")), "InsertUrl")
+	must(t, repo.InsertUrl(ctx, newUrl(userID, "go.example.com", "abc")), "InsertUrl on another domain")
+
+	if err := repo.InsertUrl(ctx, newUrl(userID, "", "abc")); !errors.Is(err, model.ErrDuplicate) {
+		t.Fatalf("InsertUrl with a taken key: got %v, want model.ErrDuplicate", err)
+	}
+
+	err := repo.InsertUrls(ctx, []*model.Url{newUrl(userID, "", "new"), newUrl(userID, "", "abc")})
+	if !errors.Is(err, model.ErrDuplicate) {
+		t.Fatalf("InsertUrls with a taken key: got %v, want model.ErrDuplicate", err)
+	}
+
+	must(t, repo.InsertUrls(ctx, []*model.Url{newUrl(userID, "", "x1"), newUrl(userID, "", "x2")}), "InsertUrls")
+
+	for _, tc := range []struct {
+		domain, key string
+		want        int64
+	}{
+		{"", "abc", 1},
+		{"go.example.com", "abc", 1},
+		{"other.example.com", "abc", 0},
+		{"", "x2", 1},
+		{"", "missing", 0},
+	} {
+		count, err := repo.CheckUniqueUrlKey(ctx, tc.domain, tc.key)
+		must(t, err, "CheckUniqueUrlKey")
+		if count != tc.want {
+			t.Fatalf("CheckUniqueUrlKey(%q, %q) = %d, want %d", tc.domain, tc.key, count, tc.want)
+		}
+	}
+
+	got, err := repo.GetUrlByKey(ctx, "go.example.com", "abc")
+	must(t, err, "GetUrlByKey")
+	if got.Domain != "go.example.com" {
+		t.Fatalf("GetUrlByKey returned the link on %q", got.Domain)
+	}
+
+	_, err = repo.GetUrlByKey(ctx, "", "missing")
+	wantNotFound(t, err, "GetUrlByKey of unknown key")
+}
+
+func testUrlUpdates(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	url := newUrl(primitive.NewObjectID(), "", "upd")
+	expires := now().Add(time.Hour)
+	url.ExpiresAt = &expires
+	must(t, repo.InsertUrl(ctx, url), "InsertUrl")
+
+	must(t, repo.RecordClicks(ctx, []*model.ClickEvent{{ID: primitive.NewObjectID(), Key: "upd", At: now(), OS: "Linux", City: "Paris"}}), "RecordClicks")
+
+	// A stale copy must not overwrite the click counters.
+	url.Label = "renamed"
+	url.Disabled = true
+	url.ExpiresAt = nil
+	url.NoOfClicks = 0
+	must(t, repo.UpdateUrl(ctx, url), "UpdateUrl")
+
+	got, err := repo.GetUrlByID(ctx, url.UrlID)
+	must(t, err, "GetUrlByID")
+	if got.Label != "renamed" || !got.Disabled || got.ExpiresAt != nil {
+		t.Fatalf("UpdateUrl did not save the editable fields: %+v", got)
+	}
+	if got.NoOfClicks != 1 {
+		t.Fatalf("UpdateUrl changed no_of_clicks to %d", got.NoOfClicks)
+	}
+
+	// Returned values are copies.
+	got.Device["Linux"] = 100
+	again, err := repo.GetUrlByID(ctx, url.UrlID)
+	must(t, err, "GetUrlByID")
+	if again.Device["Linux"] != 1 {
+		t.Fatalf("changing a returned url changed the stored one")
+	}
+
+	at := now()
+	must(t, repo.DeleteUrl(ctx, url.UrlID, at), "DeleteUrl")
+	wantNotFound(t, repo.DeleteUrl(ctx, url.UrlID, at), "DeleteUrl twice")
+	wantNotFound(t, repo.UpdateUrl(ctx, url), "UpdateUrl of a deleted url")
+	wantNotFound(t, repo.UpdateUrl(ctx, newUrl(url.UserID, "", "none")), "UpdateUrl of unknown url")
+
+	got, err = repo.GetUrlByID(ctx, url.UrlID)
+	must(t, err, "GetUrlByID of a deleted url")
+	if got.DeletedAt == nil {
+		t.Fatalf("DeleteUrl did not set deleted_at")
+	}
+}
+
+func testUrlListing(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	workspaceID := primitive.NewObjectID()
+	base := now().Add(-time.Hour)
+
+	for i := 0; i < 5; i++ {
+		url := newUrl(userID, "", fmt.Sprintf("l%d", i))
+		url.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		url.NoOfClicks = i % 2
+		if i == 3 {
+			url.LongURL = "https://docs.example.org/page"
+			url.Disabled = true
+		}
+		must(t, repo.InsertUrl(ctx, url), "InsertUrl")
+	}
+
+	deleted := newUrl(userID, "", "gone")
+	must(t, repo.InsertUrl(ctx, deleted), "InsertUrl")
+	must(t, repo.DeleteUrl(ctx, deleted.UrlID, now()), "DeleteUrl")
+
+	shared := newUrl(userID, "", "ws")
+	shared.WorkspaceID = &workspaceID
+	must(t, repo.InsertUrl(ctx, shared), "InsertUrl")
+
+	must(t, repo.InsertUrl(ctx, newUrl(primitive.NewObjectID(), "", "theirs")), "InsertUrl")
+
+	list := func(q model.ListUrlsQuery) []string {
+		t.Helper()
+		if q.Sort == "" {
+			q.Sort = "created_at"
+		}
+		urls, err := repo.GetAllURLs(ctx, userID, &q)
+		must(t, err, "GetAllURLs")
+		keys := make([]string, len(urls))
+		for i, url := range urls {
+			keys[i] = url.ShortURLKey
+		}
+		return keys
+	}
+
+	check := func(name string, got []string, want ...string) {
+		t.Helper()
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: got %v, want %v", name, got, want)
+		}
+	}
+
+	check("newest first", list(model.ListUrlsQuery{}), "l4", "l3", "l2", "l1", "l0")
+	check("oldest first", list(model.ListUrlsQuery{Order: "asc", Limit: 2}), "l0", "l1")
+	check("by clicks", list(model.ListUrlsQuery{Sort: "clicks", Order: "desc", Limit: 2}), "l3", "l1")
+	check("label filter", list(model.ListUrlsQuery{Label: "L2"}), "l2")
+	check("domain filter", list(model.ListUrlsQuery{Domain: "example.org"}), "l3")
+	check("disabled", list(model.ListUrlsQuery{Status: "disabled"}), "l3")
+	check("active", list(model.ListUrlsQuery{Status: "active", Order: "asc"}), "l0", "l1", "l2", "l4")
+	check("created range", list(model.ListUrlsQuery{CreatedFrom: base.Add(time.Minute), CreatedTo: base.Add(3 * time.Minute), Order: "asc"}), "l1", "l2")
+	check("workspace", list(model.ListUrlsQuery{Workspace: &workspaceID}), "ws")
+
+	after := &model.UrlCursor{Sort: "created_at", CreatedAt: base.Add(2 * time.Minute)}
+	urls, err := repo.GetAllURLs(ctx, userID, &model.ListUrlsQuery{Sort: "created_at", Limit: 10})
+	must(t, err, "GetAllURLs")
+	for _, url := range urls {
+		if url.ShortURLKey == "l2" {
+			after.ID = url.UrlID
+		}
+	}
+	check("after cursor", list(model.ListUrlsQuery{After: after}), "l1", "l0")
+
+	var exported []string
+	must(t, repo.EachURL(ctx, userID, func(url *model.Url) error {
+		exported = append(exported, url.ShortURLKey)
+		return nil
+	}), "EachURL")
+	check("EachURL", exported, "l0", "l1", "l2", "l3", "l4")
+}
+
+func testExpireURLs(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	at := now()
+
+	past := at.Add(-time.Minute)
+	dated := newUrl(userID, "", "dated")
+	dated.ExpiresAt = &past
+	capped := newUrl(userID, "", "capped")
+	capped.MaxClicks, capped.NoOfClicks = 2, 2
+	live := newUrl(userID, "", "live")
+	live.MaxClicks = 2
+
+	for _, url := range []*model.Url{dated, capped, live} {
+		must(t, repo.InsertUrl(ctx, url), "InsertUrl")
+	}
+
+	n, err := repo.ExpireURLs(ctx, at)
+	must(t, err, "ExpireURLs")
+	if n != 2 {
+		t.Fatalf("ExpireURLs = %d, want 2", n)
+	}
+
+	n, err = repo.ExpireURLs(ctx, at)
+	must(t, err, "ExpireURLs")
+	if n != 0 {
+		t.Fatalf("second ExpireURLs = %d, want 0", n)
+	}
+}
+
+func testClicks(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	url := newUrl(primitive.NewObjectID(), "go.example.com", "hot")
+	must(t, repo.InsertUrl(ctx, url), "InsertUrl")
+
+	const workers, perWorker = 8, 24
+	start := now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				event := &model.ClickEvent{
+					ID:      primitive.NewObjectID(),
+					Key:     "hot",
+					Domain:  "go.example.com",
+					At:      start.Add(time.Duration(w*perWorker+i) * time.Millisecond),
+					OS:      []string{"Linux", "iOS"}[i%2],
+					City:    "Paris",
+					Country: "France",
+					UTM:     model.UTM{Source: "newsletter"},
+				}
+				if err := repo.RecordClicks(ctx, []*model.ClickEvent{event}); err != nil {
+					t.Errorf("RecordClicks: %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	got, err := repo.GetUrlByID(ctx, url.UrlID)
+	must(t, err, "GetUrlByID")
+	if got.NoOfClicks != workers*perWorker {
+		t.Fatalf("no_of_clicks = %d, want %d", got.NoOfClicks, workers*perWorker)
+	}
+	if got.Device["Linux"]+got.Device["iOS"] != workers*perWorker || got.Location["Paris"] != workers*perWorker {
+		t.Fatalf("breakdowns not counted: device %v location %v", got.Device, got.Location)
+	}
+
+	filter := &model.ClickEventFilter{Key: "hot", Domain: "go.example.com", OS: "iOS", UTMSource: "newsletter", Limit: 10}
+	events, err := repo.FindClickEvents(ctx, filter)
+	must(t, err, "FindClickEvents")
+	if len(events) != 10 {
+		t.Fatalf("FindClickEvents returned %d events, want 10", len(events))
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].ID.Hex() >= events[i-1].ID.Hex() {
+			t.Fatalf("FindClickEvents not newest first")
+		}
+	}
+
+	filter.Before = events[len(events)-1].ID
+	filter.Limit = 0
+	rest, err := repo.FindClickEvents(ctx, filter)
+	must(t, err, "FindClickEvents")
+	if len(events)+len(rest) != workers*perWorker/2 {
+		t.Fatalf("paging iOS clicks found %d, want %d", len(events)+len(rest), workers*perWorker/2)
+	}
+
+	agg, err := repo.AggregateClicks(ctx, &model.ClickEventFilter{Key: "hot", Domain: "go.example.com", OS: "iOS"}, time.Hour)
+	must(t, err, "AggregateClicks")
+	if agg.Total != workers*perWorker/2 || agg.OS["iOS"] != agg.Total || agg.Countries["France"] != agg.Total || agg.Referrers[""] != agg.Total {
+		t.Fatalf("AggregateClicks = %+v, want %d iOS clicks from France", agg, workers*perWorker/2)
+	}
+	var n int
+	for bucketStart, clicks := range agg.Buckets {
+		if !bucketStart.Equal(start.Truncate(time.Hour)) && !bucketStart.Equal(start.Truncate(time.Hour).Add(time.Hour)) {
+			t.Fatalf("AggregateClicks bucket %v, want the hour of %v", bucketStart, start)
+		}
+		n += clicks
+	}
+	if n != agg.Total {
+		t.Fatalf("AggregateClicks buckets hold %d clicks, want %d", n, agg.Total)
+	}
+
+	events, err = repo.FindClickEvents(ctx, &model.ClickEventFilter{Key: "hot"})
+	must(t, err, "FindClickEvents")
+	if len(events) != 0 {
+		t.Fatalf("clicks on go.example.com matched the default domain")
+	}
+}
+
+func testClickLimit(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	url := newUrl(primitive.NewObjectID(), "", "limited")
+	url.MaxClicks = 3
+	must(t, repo.InsertUrl(ctx, url), "InsertUrl")
+
+	var taken int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.TakeClick(ctx, url.UrlID)
+			if err == nil {
+				atomic.AddInt64(&taken, 1)
+			} else if err != mongo.ErrNoDocuments {
+				t.Errorf("TakeClick: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if taken != 3 {
+		t.Fatalf("TakeClick succeeded %d times, want 3", taken)
+	}
+
+	// The pipeline records taken clicks without counting them again.
+	event := &model.ClickEvent{ID: primitive.NewObjectID(), Key: "limited", At: now(), OS: "Linux", City: "Paris", Counted: true}
+	must(t, repo.RecordClicks(ctx, []*model.ClickEvent{event}), "RecordClicks")
+
+	got, err := repo.GetUrlByID(ctx, url.UrlID)
+	must(t, err, "GetUrlByID")
+	if got.NoOfClicks != 3 || got.Device["Linux"] != 1 {
+		t.Fatalf("no_of_clicks = %d, device %v; want 3 and one Linux click", got.NoOfClicks, got.Device)
+	}
+}
+
+// testClickBreakdownNames records names that Mongo can't take as field
+// names as they are.
+func testClickBreakdownNames(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	url := newUrl(primitive.NewObjectID(), "", "odd")
+	must(t, repo.InsertUrl(ctx, url), "InsertUrl")
+
+	names := [][2]string{{"Mac OS X 10.15", "St. Louis"}, {"$where", "100%2E"}, {"", ""}}
+	for _, name := range names {
+		event := &model.ClickEvent{ID: primitive.NewObjectID(), Key: "odd", At: now(), OS: name[0], City: name[1]}
+		must(t, repo.RecordClicks(ctx, []*model.ClickEvent{event}), "RecordClicks")
+	}
+
+	got, err := repo.GetUrlByID(ctx, url.UrlID)
+	must(t, err, "GetUrlByID")
+	if got.NoOfClicks != len(names) {
+		t.Fatalf("no_of_clicks = %d, want %d", got.NoOfClicks, len(names))
+	}
+	for _, name := range names {
+		if got.Device[name[0]] != 1 || got.Location[name[1]] != 1 {
+			t.Fatalf("breakdowns = device %v location %v, want one click each for %q and %q", got.Device, got.Location, name[0], name[1])
+		}
+	}
+}
+
+func testDomains(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	domain := &model.Domain{DomainID: primitive.NewObjectID(), UserID: userID, Host: "go.example.com", VerificationToken: "tok", CreatedAt: now()}
+	must(t, repo.InsertDomain(ctx, domain), "InsertDomain")
+
+	again := &model.Domain{DomainID: primitive.NewObjectID(), UserID: userID, Host: "go.example.com", VerificationToken: "tok2", CreatedAt: now()}
+	if err := repo.InsertDomain(ctx, again); !errors.Is(err, model.ErrDuplicate) {
+		t.Fatalf("InsertDomain of a host the user already claimed: got %v, want ErrDuplicate", err)
+	}
+
+	// Another user's claim on the same host doesn't clash until one of
+	// them is verified.
+	rival := &model.Domain{DomainID: primitive.NewObjectID(), UserID: primitive.NewObjectID(), Host: "go.example.com", VerificationToken: "tok3", CreatedAt: now()}
+	must(t, repo.InsertDomain(ctx, rival), "InsertDomain of a rival claim")
+
+	_, err := repo.GetDomainByHost(ctx, "go.example.com")
+	wantNotFound(t, err, "GetDomainByHost before verification")
+
+	at := now()
+	must(t, repo.VerifyDomain(ctx, domain.DomainID, at), "VerifyDomain")
+	wantNotFound(t, repo.VerifyDomain(ctx, primitive.NewObjectID(), at), "VerifyDomain of unknown domain")
+
+	if err := repo.VerifyDomain(ctx, rival.DomainID, at); !errors.Is(err, model.ErrDuplicate) {
+		t.Fatalf("VerifyDomain of a rival claim: got %v, want ErrDuplicate", err)
+	}
+
+	got, err := repo.GetDomainByHost(ctx, "go.example.com")
+	must(t, err, "GetDomainByHost")
+	if got.DomainID != domain.DomainID {
+		t.Fatalf("GetDomainByHost returned %s", got.DomainID.Hex())
+	}
+
+	_, err = repo.GetDomainByHost(ctx, "other.example.com")
+	wantNotFound(t, err, "GetDomainByHost of unknown host")
+
+	got, err = repo.GetDomainByID(ctx, domain.DomainID)
+	must(t, err, "GetDomainByID")
+	if !got.Verified || got.VerifiedAt == nil {
+		t.Fatalf("VerifyDomain did not verify: %+v", got)
+	}
+
+	domains, err := repo.GetDomainsByUser(ctx, userID)
+	must(t, err, "GetDomainsByUser")
+	if len(domains) != 1 {
+		t.Fatalf("GetDomainsByUser returned %d domains", len(domains))
+	}
+}
+
+func testWorkspaces(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	ownerID := primitive.NewObjectID()
+	at := now()
+
+	workspace := &model.Workspace{WorkspaceID: primitive.NewObjectID(), Name: "Team", OwnerID: ownerID, CreatedAt: at}
+	must(t, repo.InsertWorkspace(ctx, workspace), "InsertWorkspace")
+
+	owner := &model.WorkspaceMember{MemberID: primitive.NewObjectID(), WorkspaceID: workspace.WorkspaceID, UserID: &ownerID, Email: "o@example.com", Role: model.RoleOwner, Status: "active", CreatedAt: at, JoinedAt: &at}
+	expires := at.Add(time.Hour)
+	invite := &model.WorkspaceMember{MemberID: primitive.NewObjectID(), WorkspaceID: workspace.WorkspaceID, Email: "i@example.com", Role: model.RoleEditor, Status: "invited", InviteTokenHash: "inv", InvitedBy: ownerID, CreatedAt: at, ExpiresAt: &expires}
+	must(t, repo.InsertWorkspaceMember(ctx, owner), "InsertWorkspaceMember")
+	must(t, repo.InsertWorkspaceMember(ctx, invite), "InsertWorkspaceMember")
+
+	got, err := repo.GetWorkspaceMember(ctx, workspace.WorkspaceID, ownerID)
+	must(t, err, "GetWorkspaceMember")
+	if got.Role != model.RoleOwner {
+		t.Fatalf("GetWorkspaceMember role = %q", got.Role)
+	}
+
+	pending, err := repo.GetWorkspaceMemberByInviteToken(ctx, "inv")
+	must(t, err, "GetWorkspaceMemberByInviteToken")
+	if pending.ExpiresAt == nil || !pending.ExpiresAt.Equal(expires) {
+		t.Fatalf("GetWorkspaceMemberByInviteToken expires_at = %v, want %v", pending.ExpiresAt, expires)
+	}
+
+	inviteeID := primitive.NewObjectID()
+	_, err = repo.GetWorkspaceMember(ctx, workspace.WorkspaceID, inviteeID)
+	wantNotFound(t, err, "GetWorkspaceMember before accepting")
+
+	pending.UserID, pending.Status, pending.InviteTokenHash, pending.ExpiresAt = &inviteeID, "active", "", nil
+	must(t, repo.UpdateWorkspaceMember(ctx, pending), "UpdateWorkspaceMember")
+
+	_, err = repo.GetWorkspaceMemberByInviteToken(ctx, "inv")
+	wantNotFound(t, err, "GetWorkspaceMemberByInviteToken after accepting")
+
+	workspaces, err := repo.GetWorkspacesByUser(ctx, inviteeID)
+	must(t, err, "GetWorkspacesByUser")
+	if len(workspaces) != 1 || workspaces[0].WorkspaceID != workspace.WorkspaceID {
+		t.Fatalf("GetWorkspacesByUser = %+v", workspaces)
+	}
+
+	members, err := repo.GetWorkspaceMembers(ctx, workspace.WorkspaceID)
+	must(t, err, "GetWorkspaceMembers")
+	if len(members) != 2 {
+		t.Fatalf("GetWorkspaceMembers returned %d members", len(members))
+	}
+
+	must(t, repo.DeleteWorkspaceMember(ctx, invite.MemberID), "DeleteWorkspaceMember")
+	wantNotFound(t, repo.DeleteWorkspaceMember(ctx, invite.MemberID), "DeleteWorkspaceMember twice")
+	wantNotFound(t, repo.UpdateWorkspaceMember(ctx, invite), "UpdateWorkspaceMember of a removed member")
+
+	_, err = repo.GetWorkspaceByID(ctx, primitive.NewObjectID())
+	wantNotFound(t, err, "GetWorkspaceByID of unknown workspace")
+}
+
+func testApiKeys(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	at := now()
+
+	key := &model.ApiKey{KeyID: primitive.NewObjectID(), UserID: userID, Name: "ci", Prefix: "sk_abc", KeyHash: "kh", Scopes: []string{model.ScopeLinksWrite}, CreatedAt: at}
+	must(t, repo.InsertApiKey(ctx, key), "InsertApiKey")
+
+	must(t, repo.TouchApiKey(ctx, key.KeyID, at), "TouchApiKey")
+
+	got, err := repo.GetApiKeyByHash(ctx, "kh")
+	must(t, err, "GetApiKeyByHash")
+	if got.LastUsedAt == nil || len(got.Scopes) != 1 {
+		t.Fatalf("GetApiKeyByHash = %+v", got)
+	}
+
+	wantNotFound(t, repo.RevokeApiKey(ctx, key.KeyID, primitive.NewObjectID(), at), "RevokeApiKey of another user's key")
+	must(t, repo.RevokeApiKey(ctx, key.KeyID, userID, at), "RevokeApiKey")
+	wantNotFound(t, repo.RevokeApiKey(ctx, key.KeyID, userID, at), "RevokeApiKey twice")
+
+	keys, err := repo.GetApiKeysByUser(ctx, userID)
+	must(t, err, "GetApiKeysByUser")
+	if len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Fatalf("GetApiKeysByUser = %+v", keys)
+	}
+}
+
+func testBlockRules(t *testing.T, repo model.UserRepositoryInterface) {
+	ctx := context.Background()
+
+	rule := &model.BlockRule{RuleID: primitive.NewObjectID(), Type: "domain", Pattern: "bad.example", Action: "block", CreatedAt: now()}
+	must(t, repo.InsertBlockRule(ctx, rule), "InsertBlockRule")
+
+	rules, err := repo.GetBlockRules(ctx)
+	must(t, err, "GetBlockRules")
+	if len(rules) != 1 || rules[0].Pattern != "bad.example" {
+		t.Fatalf("GetBlockRules = %+v", rules)
+	}
+
+	must(t, repo.DeleteBlockRule(ctx, rule.RuleID), "DeleteBlockRule")
+	wantNotFound(t, repo.DeleteBlockRule(ctx, rule.RuleID), "DeleteBlockRule twice")
+}
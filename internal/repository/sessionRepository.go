@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (u *userRepo) InsertSession(ctx context.Context, session *model.Session) error {
+	_, err := u.db.Collection("session").InsertOne(ctx, session)
+	return err
+}
+
+func (u *userRepo) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*model.Session, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"token_hash": tokenHash},
+		bson.M{"previous_hashes": tokenHash},
+	}}
+
+	var session model.Session
+	err := u.db.Collection("session").FindOne(ctx, filter).Decode(&session)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (u *userRepo) GetSessionsByUser(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]model.Session, error) {
+	filter := bson.M{"user_id": userID, "revoked_at": nil, "expires_at": bson.M{"$gt": now}}
+	opts := options.Find().SetSort(bson.M{"last_used_at": -1})
+
+	cursor, err := u.db.Collection("session").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []model.Session{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (u *userRepo) RotateSession(ctx context.Context, session *model.Session, oldHash string) error {
+	filter := bson.M{"_id": session.SessionID, "token_hash": oldHash, "revoked_at": nil}
+	update := bson.M{
+		"$set": bson.M{
+			"token_hash":   session.TokenHash,
+			"user_agent":   session.UserAgent,
+			"ip":           session.IP,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+		},
+		"$push": bson.M{"previous_hashes": oldHash},
+	}
+
+	res, err := u.db.Collection("session").UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) RevokeSession(ctx context.Context, sessionID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"_id": sessionID, "user_id": userID, "revoked_at": nil}
+
+	res, err := u.db.Collection("session").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) RevokeSessionsByUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	filter := bson.M{"user_id": userID, "revoked_at": nil}
+
+	_, err := u.db.Collection("session").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked_at": at}})
+	return err
+}
@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+)
+
+type sqliteMigration struct {
+	version int
+	name    string
+	up      string
+}
+
+// sqliteMigrations are applied in order and never edited once released;
+// schema changes go in a new entry. IDs are ObjectID hex strings and times
+// are Unix milliseconds, the precision Mongo keeps.
+var sqliteMigrations = []sqliteMigration{
+	{1, "initial schema", `
+CREATE TABLE user (
+	user_id           TEXT PRIMARY KEY,
+	full_name         TEXT NOT NULL,
+	email             TEXT NOT NULL UNIQUE,
+	password          TEXT NOT NULL,
+	created_at        INTEGER NOT NULL,
+	email_verified    INTEGER NOT NULL DEFAULT 0,
+	email_verified_at INTEGER
+);
+
+CREATE TABLE user_token (
+	token_id   TEXT PRIMARY KEY,
+	user_id    TEXT NOT NULL,
+	purpose    TEXT NOT NULL,
+	token_hash TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used_at    INTEGER
+);
+CREATE INDEX user_token_hash ON user_token (token_hash);
+CREATE INDEX user_token_user ON user_token (user_id, purpose);
+
+CREATE TABLE session (
+	session_id   TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL,
+	token_hash   TEXT NOT NULL UNIQUE,
+	user_agent   TEXT NOT NULL DEFAULT '',
+	ip           TEXT NOT NULL DEFAULT '',
+	created_at   INTEGER NOT NULL,
+	last_used_at INTEGER NOT NULL,
+	expires_at   INTEGER NOT NULL,
+	revoked_at   INTEGER
+);
+CREATE INDEX session_user ON session (user_id);
+
+CREATE TABLE session_rotated_token (
+	token_hash TEXT PRIMARY KEY,
+	session_id TEXT NOT NULL REFERENCES session (session_id) ON DELETE CASCADE
+);
+CREATE INDEX session_rotated_token_session ON session_rotated_token (session_id);
+
+CREATE TABLE url (
+	url_id        TEXT PRIMARY KEY,
+	user_id       TEXT NOT NULL,
+	workspace_id  TEXT,
+	label         TEXT NOT NULL DEFAULT '',
+	long_url      TEXT NOT NULL,
+	short_url_key TEXT NOT NULL,
+	domain        TEXT NOT NULL DEFAULT '',
+	redirect_type INTEGER NOT NULL DEFAULT 0,
+	expires_at    INTEGER,
+	max_clicks    INTEGER NOT NULL DEFAULT 0,
+	fallback_url  TEXT NOT NULL DEFAULT '',
+	expired       INTEGER NOT NULL DEFAULT 0,
+	protected     INTEGER NOT NULL DEFAULT 0,
+	password_hash TEXT NOT NULL DEFAULT '',
+	disabled      INTEGER NOT NULL DEFAULT 0,
+	deleted_at    INTEGER,
+	tags          TEXT NOT NULL DEFAULT '[]',
+	no_of_clicks  INTEGER NOT NULL DEFAULT 0,
+	device        TEXT NOT NULL DEFAULT '{}',
+	location      TEXT NOT NULL DEFAULT '{}',
+	created_at    INTEGER NOT NULL,
+	updated_at    INTEGER,
+	UNIQUE (domain, short_url_key)
+);
+CREATE INDEX url_user ON url (user_id, created_at);
+CREATE INDEX url_workspace ON url (workspace_id, created_at);
+
+CREATE TABLE click (
+	click_id     TEXT PRIMARY KEY,
+	key          TEXT NOT NULL,
+	domain       TEXT NOT NULL DEFAULT '',
+	at           INTEGER NOT NULL,
+	ip_hash      TEXT NOT NULL DEFAULT '',
+	country      TEXT NOT NULL DEFAULT '',
+	city         TEXT NOT NULL DEFAULT '',
+	os           TEXT NOT NULL DEFAULT '',
+	browser      TEXT NOT NULL DEFAULT '',
+	device_type  TEXT NOT NULL DEFAULT '',
+	referrer     TEXT NOT NULL DEFAULT '',
+	utm_source   TEXT NOT NULL DEFAULT '',
+	utm_medium   TEXT NOT NULL DEFAULT '',
+	utm_campaign TEXT NOT NULL DEFAULT '',
+	utm_term     TEXT NOT NULL DEFAULT '',
+	utm_content  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX click_link_at ON click (domain, key, at);
+CREATE INDEX click_link_id ON click (domain, key, click_id);
+
+CREATE TABLE domain (
+	domain_id          TEXT PRIMARY KEY,
+	user_id            TEXT NOT NULL,
+	host               TEXT NOT NULL UNIQUE,
+	verification_token TEXT NOT NULL,
+	verified           INTEGER NOT NULL DEFAULT 0,
+	verified_at        INTEGER,
+	created_at         INTEGER NOT NULL
+);
+CREATE INDEX domain_user ON domain (user_id);
+
+CREATE TABLE workspace (
+	workspace_id TEXT PRIMARY KEY,
+	name         TEXT NOT NULL,
+	owner_id     TEXT NOT NULL,
+	created_at   INTEGER NOT NULL
+);
+
+CREATE TABLE workspace_member (
+	member_id         TEXT PRIMARY KEY,
+	workspace_id      TEXT NOT NULL,
+	user_id           TEXT,
+	email             TEXT NOT NULL DEFAULT '',
+	role              TEXT NOT NULL,
+	status            TEXT NOT NULL,
+	invite_token_hash TEXT NOT NULL DEFAULT '',
+	invited_by        TEXT NOT NULL DEFAULT '',
+	created_at        INTEGER NOT NULL,
+	joined_at         INTEGER
+);
+CREATE INDEX workspace_member_workspace ON workspace_member (workspace_id, user_id);
+CREATE INDEX workspace_member_user ON workspace_member (user_id);
+CREATE INDEX workspace_member_invite ON workspace_member (invite_token_hash);
+
+CREATE TABLE api_key (
+	key_id       TEXT PRIMARY KEY,
+	user_id      TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	prefix       TEXT NOT NULL,
+	key_hash     TEXT NOT NULL UNIQUE,
+	scopes       TEXT NOT NULL DEFAULT '[]',
+	created_at   INTEGER NOT NULL,
+	last_used_at INTEGER,
+	revoked_at   INTEGER
+);
+CREATE INDEX api_key_user ON api_key (user_id);
+
+CREATE TABLE block_rule (
+	rule_id    TEXT PRIMARY KEY,
+	type       TEXT NOT NULL,
+	pattern    TEXT NOT NULL,
+	action     TEXT NOT NULL,
+	reason     TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL
+);
+`},
+	// Anyone may claim a host; it belongs to whoever verifies it first.
+	{2, "unique verified domain hosts", `
+CREATE TABLE domain_new (
+	domain_id          TEXT PRIMARY KEY,
+	user_id            TEXT NOT NULL,
+	host               TEXT NOT NULL,
+	verification_token TEXT NOT NULL,
+	verified           INTEGER NOT NULL DEFAULT 0,
+	verified_at        INTEGER,
+	created_at         INTEGER NOT NULL,
+	UNIQUE (user_id, host)
+);
+INSERT INTO domain_new SELECT domain_id, user_id, host, verification_token, verified, verified_at, created_at FROM domain;
+DROP TABLE domain;
+ALTER TABLE domain_new RENAME TO domain;
+CREATE UNIQUE INDEX domain_verified_host ON domain (host) WHERE verified = 1;
+`},
+	{3, "workspace invitation expiry", `
+ALTER TABLE workspace_member ADD COLUMN expires_at INTEGER;
+`},
+}
+
+// MigrateSQLite brings the schema up to date. Each migration runs in its
+// own transaction together with the row recording it, so a failed
+// migration leaves no trace and two processes starting at once cannot
+// apply the same one twice.
+func MigrateSQLite(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migration (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT NOT NULL,
+	applied_at INTEGER NOT NULL
+)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migration").Scan(&current); err != nil {
+		return err
+	}
+
+	for _, m := range sqliteMigrations {
+		if m.version <= current {
+			continue
+		}
+
+		if err := applySQLiteMigration(ctx, db, m); err != nil {
+			if errors.Is(err, model.ErrDuplicate) {
+				continue
+			}
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+
+	return nil
+}
+
+func applySQLiteMigration(ctx context.Context, db *sql.DB, m sqliteMigration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Recording the migration first fails fast if another process got to
+	// it while we waited for the write lock.
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migration (version, name, applied_at) VALUES (?, ?, ?)",
+		m.version, m.name, sqlTime(time.Now()))
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, m.up); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
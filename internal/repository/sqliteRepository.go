@@ -0,0 +1,714 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteRepo stores everything in an embedded SQLite database, for small
+// deployments that don't want to run MongoDB. It follows the Mongo
+// repository's semantics, including returning mongo.ErrNoDocuments when
+// nothing matches. The schema is created by MigrateSQLite.
+type sqliteRepo struct {
+	db *sql.DB
+}
+
+func NewSQLiteRepository(db *sql.DB) model.UserRepositoryInterface {
+	return &sqliteRepo{
+		db,
+	}
+}
+
+func init() {
+	// SQLite parses "x REGEXP y" but leaves regexp() to the application.
+	sqlite.MustRegisterDeterministicScalarFunction("regexp", 2, sqliteRegexp)
+}
+
+var sqliteRegexps = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+func sqliteRegexp(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+	pattern, ok := args[0].(string)
+	if !ok {
+		return nil, errors.New("regexp: pattern must be text")
+	}
+	value, ok := args[1].(string)
+	if !ok {
+		return false, nil
+	}
+
+	sqliteRegexps.Lock()
+	re, ok := sqliteRegexps.m[pattern]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			sqliteRegexps.Unlock()
+			return nil, err
+		}
+		// Patterns come from queries, so don't let the cache grow forever.
+		if len(sqliteRegexps.m) >= 256 {
+			sqliteRegexps.m = make(map[string]*regexp.Regexp)
+		}
+		sqliteRegexps.m[pattern] = re
+	}
+	sqliteRegexps.Unlock()
+
+	return re.MatchString(value), nil
+}
+
+// sqliteError translates driver errors into the ones the Mongo repository
+// returns, so callers need not know which backend they talk to.
+func sqliteError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return mongo.ErrNoDocuments
+	}
+
+	var se *sqlite.Error
+	if errors.As(err, &se) {
+		switch se.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return fmt.Errorf("%w: %v", model.ErrDuplicate, err)
+		}
+	}
+
+	return err
+}
+
+// requireChange is for updates and deletes that must match a row.
+func requireChange(res sql.Result, err error) error {
+	if err != nil {
+		return sqliteError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (s *sqliteRepo) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return sqliteError(err)
+	}
+
+	return tx.Commit()
+}
+
+// sqlExecer is a *sql.DB or a *sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func sqlTime(t time.Time) int64 {
+	return t.UnixMilli()
+}
+
+// sqlNullTime stores nil, and the zero time, as NULL.
+func sqlNullTime(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+func sqlNullID(id *primitive.ObjectID) interface{} {
+	if id == nil {
+		return nil
+	}
+	return id.Hex()
+}
+
+func sqlJSON(v interface{}) string {
+	// Only string slices and counters are stored this way, which always
+	// marshal.
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func sqlCounts(m map[string]int) string {
+	if m == nil {
+		return "{}"
+	}
+	return sqlJSON(m)
+}
+
+func sqlStrings(s []string) string {
+	if s == nil {
+		return "[]"
+	}
+	return sqlJSON(s)
+}
+
+// sqlText reads a TEXT column, which the driver may hand over as a string
+// or as bytes.
+func sqlText(src interface{}) (string, bool) {
+	switch v := src.(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+type idScanner struct{ id *primitive.ObjectID }
+
+func scanID(id *primitive.ObjectID) idScanner { return idScanner{id} }
+
+func (s idScanner) Scan(src interface{}) error {
+	text, ok := sqlText(src)
+	if !ok {
+		return fmt.Errorf("scanning id: unexpected %T", src)
+	}
+	if text == "" {
+		*s.id = primitive.NilObjectID
+		return nil
+	}
+
+	id, err := primitive.ObjectIDFromHex(text)
+	if err != nil {
+		return err
+	}
+	*s.id = id
+	return nil
+}
+
+type nullIDScanner struct{ id **primitive.ObjectID }
+
+func scanNullID(id **primitive.ObjectID) nullIDScanner { return nullIDScanner{id} }
+
+func (s nullIDScanner) Scan(src interface{}) error {
+	if src == nil {
+		*s.id = nil
+		return nil
+	}
+
+	var id primitive.ObjectID
+	if err := scanID(&id).Scan(src); err != nil {
+		return err
+	}
+	*s.id = &id
+	return nil
+}
+
+type timeScanner struct{ t *time.Time }
+
+// scanTime reads a time column, leaving the zero time for NULL.
+func scanTime(t *time.Time) timeScanner { return timeScanner{t} }
+
+func (s timeScanner) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s.t = time.Time{}
+	case int64:
+		*s.t = time.UnixMilli(v).UTC()
+	default:
+		return fmt.Errorf("scanning time: unexpected %T", src)
+	}
+	return nil
+}
+
+type nullTimeScanner struct{ t **time.Time }
+
+func scanNullTime(t **time.Time) nullTimeScanner { return nullTimeScanner{t} }
+
+func (s nullTimeScanner) Scan(src interface{}) error {
+	if src == nil {
+		*s.t = nil
+		return nil
+	}
+
+	var t time.Time
+	if err := scanTime(&t).Scan(src); err != nil {
+		return err
+	}
+	*s.t = &t
+	return nil
+}
+
+type jsonScanner struct{ v interface{} }
+
+func scanJSON(v interface{}) jsonScanner { return jsonScanner{v} }
+
+func (s jsonScanner) Scan(src interface{}) error {
+	text, ok := sqlText(src)
+	if !ok {
+		return fmt.Errorf("scanning json: unexpected %T", src)
+	}
+	return json.Unmarshal([]byte(text), s.v)
+}
+
+const userColumns = "user_id, full_name, email, password, created_at, email_verified, email_verified_at"
+
+func scanUser(row rowScanner) (*model.User, error) {
+	var user model.User
+	err := row.Scan(scanID(&user.UserID), &user.FullName, &user.Email, &user.Password,
+		scanTime(&user.Created_at), &user.EmailVerified, scanNullTime(&user.EmailVerifiedAt))
+	return &user, sqliteError(err)
+}
+
+func (s *sqliteRepo) Signup(ctx context.Context, user *model.User) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO user ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		user.UserID.Hex(), user.FullName, user.Email, user.Password, sqlTime(user.Created_at),
+		user.EmailVerified, sqlNullTime(user.EmailVerifiedAt))
+	return sqliteError(err)
+}
+
+func (s *sqliteRepo) CheckUniqueEmail(ctx context.Context, email string) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM user WHERE email = ?", email).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteRepo) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM user WHERE email = ?", email))
+}
+
+func (s *sqliteRepo) GetUserById(ctx context.Context, userID primitive.ObjectID) (*model.User, error) {
+	return scanUser(s.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM user WHERE user_id = ?", userID.Hex()))
+}
+
+func (s *sqliteRepo) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	return requireChange(s.db.ExecContext(ctx, "UPDATE user SET email_verified = 1, email_verified_at = ? WHERE user_id = ?",
+		sqlTime(at), userID.Hex()))
+}
+
+func (s *sqliteRepo) UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error {
+	return requireChange(s.db.ExecContext(ctx, "UPDATE user SET password = ? WHERE user_id = ?", passwordHash, userID.Hex()))
+}
+
+const userTokenColumns = "token_id, user_id, purpose, token_hash, created_at, expires_at, used_at"
+
+func (s *sqliteRepo) InsertUserToken(ctx context.Context, token *model.UserToken) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO user_token ("+userTokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		token.TokenID.Hex(), token.UserID.Hex(), token.Purpose, token.TokenHash,
+		sqlTime(token.CreatedAt), sqlTime(token.ExpiresAt), sqlNullTime(token.UsedAt))
+	return sqliteError(err)
+}
+
+func (s *sqliteRepo) UseUserToken(ctx context.Context, tokenHash string, purpose string, now time.Time) (*model.UserToken, error) {
+	row := s.db.QueryRowContext(ctx, `UPDATE user_token SET used_at = ?
+		WHERE token_id = (
+			SELECT token_id FROM user_token
+			WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+			LIMIT 1
+		)
+		RETURNING `+userTokenColumns,
+		sqlTime(now), tokenHash, purpose, sqlTime(now))
+
+	var token model.UserToken
+	err := row.Scan(scanID(&token.TokenID), scanID(&token.UserID), &token.Purpose, &token.TokenHash,
+		scanTime(&token.CreatedAt), scanTime(&token.ExpiresAt), scanNullTime(&token.UsedAt))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return &token, nil
+}
+
+func (s *sqliteRepo) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM user_token WHERE user_id = ? AND purpose = ?", userID.Hex(), purpose)
+	return err
+}
+
+// Rotated refresh tokens live in their own table so they can be looked up
+// by index; they are read back in the order they were rotated out.
+const sessionColumns = `session_id, user_id, token_hash,
+	(SELECT json_group_array(token_hash) FROM (
+		SELECT token_hash FROM session_rotated_token r
+		WHERE r.session_id = session.session_id ORDER BY r.rowid
+	)),
+	user_agent, ip, created_at, last_used_at, expires_at, revoked_at`
+
+func scanSession(row rowScanner) (*model.Session, error) {
+	var session model.Session
+	err := row.Scan(scanID(&session.SessionID), scanID(&session.UserID), &session.TokenHash,
+		scanJSON(&session.PreviousHashes), &session.UserAgent, &session.IP, scanTime(&session.CreatedAt),
+		scanTime(&session.LastUsedAt), scanTime(&session.ExpiresAt), scanNullTime(&session.RevokedAt))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return &session, nil
+}
+
+func (s *sqliteRepo) InsertSession(ctx context.Context, session *model.Session) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO session
+			(session_id, user_id, token_hash, user_agent, ip, created_at, last_used_at, expires_at, revoked_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			session.SessionID.Hex(), session.UserID.Hex(), session.TokenHash, session.UserAgent, session.IP,
+			sqlTime(session.CreatedAt), sqlTime(session.LastUsedAt), sqlTime(session.ExpiresAt),
+			sqlNullTime(session.RevokedAt))
+		if err != nil {
+			return err
+		}
+
+		for _, hash := range session.PreviousHashes {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO session_rotated_token (token_hash, session_id) VALUES (?, ?)",
+				hash, session.SessionID.Hex()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *sqliteRepo) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*model.Session, error) {
+	return scanSession(s.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM session
+		WHERE token_hash = ?
+		OR session_id = (SELECT session_id FROM session_rotated_token WHERE token_hash = ?)
+		LIMIT 1`,
+		tokenHash, tokenHash))
+}
+
+func (s *sqliteRepo) GetSessionsByUser(ctx context.Context, userID primitive.ObjectID, now time.Time) ([]model.Session, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+sessionColumns+` FROM session
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_used_at DESC`,
+		userID.Hex(), sqlTime(now))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *session)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *sqliteRepo) RotateSession(ctx context.Context, session *model.Session, oldHash string) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		err := requireChange(tx.ExecContext(ctx, `UPDATE session
+			SET token_hash = ?, user_agent = ?, ip = ?, last_used_at = ?, expires_at = ?
+			WHERE session_id = ? AND token_hash = ? AND revoked_at IS NULL`,
+			session.TokenHash, session.UserAgent, session.IP, sqlTime(session.LastUsedAt), sqlTime(session.ExpiresAt),
+			session.SessionID.Hex(), oldHash))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO session_rotated_token (token_hash, session_id) VALUES (?, ?)",
+			oldHash, session.SessionID.Hex())
+		return err
+	})
+}
+
+func (s *sqliteRepo) RevokeSession(ctx context.Context, sessionID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error {
+	return requireChange(s.db.ExecContext(ctx,
+		"UPDATE session SET revoked_at = ? WHERE session_id = ? AND user_id = ? AND revoked_at IS NULL",
+		sqlTime(at), sessionID.Hex(), userID.Hex()))
+}
+
+func (s *sqliteRepo) RevokeSessionsByUser(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE session SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL",
+		sqlTime(at), userID.Hex())
+	return err
+}
+
+const domainColumns = "domain_id, user_id, host, verification_token, verified, verified_at, created_at"
+
+func scanDomain(row rowScanner) (*model.Domain, error) {
+	var domain model.Domain
+	err := row.Scan(scanID(&domain.DomainID), scanID(&domain.UserID), &domain.Host, &domain.VerificationToken,
+		&domain.Verified, scanNullTime(&domain.VerifiedAt), scanTime(&domain.CreatedAt))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return &domain, nil
+}
+
+func (s *sqliteRepo) InsertDomain(ctx context.Context, domain *model.Domain) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO domain ("+domainColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		domain.DomainID.Hex(), domain.UserID.Hex(), domain.Host, domain.VerificationToken,
+		domain.Verified, sqlNullTime(domain.VerifiedAt), sqlTime(domain.CreatedAt))
+	return sqliteError(err)
+}
+
+func (s *sqliteRepo) GetDomainByID(ctx context.Context, domainID primitive.ObjectID) (*model.Domain, error) {
+	return scanDomain(s.db.QueryRowContext(ctx, "SELECT "+domainColumns+" FROM domain WHERE domain_id = ?", domainID.Hex()))
+}
+
+func (s *sqliteRepo) GetDomainByHost(ctx context.Context, host string) (*model.Domain, error) {
+	return scanDomain(s.db.QueryRowContext(ctx, "SELECT "+domainColumns+" FROM domain WHERE host = ? AND verified = 1", host))
+}
+
+func (s *sqliteRepo) GetDomainsByUser(ctx context.Context, userID primitive.ObjectID) ([]model.Domain, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+domainColumns+" FROM domain WHERE user_id = ? ORDER BY domain_id", userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.Domain{}
+	for rows.Next() {
+		domain, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *domain)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *sqliteRepo) VerifyDomain(ctx context.Context, domainID primitive.ObjectID, at time.Time) error {
+	return requireChange(s.db.ExecContext(ctx, "UPDATE domain SET verified = 1, verified_at = ? WHERE domain_id = ?",
+		sqlTime(at), domainID.Hex()))
+}
+
+const workspaceColumns = "workspace_id, name, owner_id, created_at"
+
+func scanWorkspace(row rowScanner) (*model.Workspace, error) {
+	var workspace model.Workspace
+	err := row.Scan(scanID(&workspace.WorkspaceID), &workspace.Name, scanID(&workspace.OwnerID), scanTime(&workspace.CreatedAt))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return &workspace, nil
+}
+
+func (s *sqliteRepo) InsertWorkspace(ctx context.Context, workspace *model.Workspace) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO workspace ("+workspaceColumns+") VALUES (?, ?, ?, ?)",
+		workspace.WorkspaceID.Hex(), workspace.Name, workspace.OwnerID.Hex(), sqlTime(workspace.CreatedAt))
+	return sqliteError(err)
+}
+
+func (s *sqliteRepo) GetWorkspaceByID(ctx context.Context, workspaceID primitive.ObjectID) (*model.Workspace, error) {
+	return scanWorkspace(s.db.QueryRowContext(ctx, "SELECT "+workspaceColumns+" FROM workspace WHERE workspace_id = ?", workspaceID.Hex()))
+}
+
+func (s *sqliteRepo) GetWorkspacesByUser(ctx context.Context, userID primitive.ObjectID) ([]model.Workspace, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+workspaceColumns+` FROM workspace
+		WHERE workspace_id IN (SELECT workspace_id FROM workspace_member WHERE user_id = ? AND status = 'active')
+		ORDER BY workspace_id`,
+		userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.Workspace{}
+	for rows.Next() {
+		workspace, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *workspace)
+	}
+
+	return res, rows.Err()
+}
+
+const workspaceMemberColumns = "member_id, workspace_id, user_id, email, role, status, invite_token_hash, invited_by, created_at, joined_at, expires_at"
+
+func scanWorkspaceMember(row rowScanner) (*model.WorkspaceMember, error) {
+	var member model.WorkspaceMember
+	err := row.Scan(scanID(&member.MemberID), scanID(&member.WorkspaceID), scanNullID(&member.UserID), &member.Email,
+		&member.Role, &member.Status, &member.InviteTokenHash, scanID(&member.InvitedBy),
+		scanTime(&member.CreatedAt), scanNullTime(&member.JoinedAt), scanNullTime(&member.ExpiresAt))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return &member, nil
+}
+
+func workspaceMemberValues(member *model.WorkspaceMember) []interface{} {
+	invitedBy := ""
+	if !member.InvitedBy.IsZero() {
+		invitedBy = member.InvitedBy.Hex()
+	}
+
+	return []interface{}{
+		member.MemberID.Hex(), member.WorkspaceID.Hex(), sqlNullID(member.UserID), member.Email,
+		member.Role, member.Status, member.InviteTokenHash, invitedBy,
+		sqlTime(member.CreatedAt), sqlNullTime(member.JoinedAt), sqlNullTime(member.ExpiresAt),
+	}
+}
+
+func (s *sqliteRepo) InsertWorkspaceMember(ctx context.Context, member *model.WorkspaceMember) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO workspace_member ("+workspaceMemberColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		workspaceMemberValues(member)...)
+	return sqliteError(err)
+}
+
+func (s *sqliteRepo) GetWorkspaceMember(ctx context.Context, workspaceID primitive.ObjectID, userID primitive.ObjectID) (*model.WorkspaceMember, error) {
+	return scanWorkspaceMember(s.db.QueryRowContext(ctx, `SELECT `+workspaceMemberColumns+` FROM workspace_member
+		WHERE workspace_id = ? AND user_id = ? AND status = 'active' LIMIT 1`,
+		workspaceID.Hex(), userID.Hex()))
+}
+
+func (s *sqliteRepo) GetWorkspaceMemberByID(ctx context.Context, memberID primitive.ObjectID) (*model.WorkspaceMember, error) {
+	return scanWorkspaceMember(s.db.QueryRowContext(ctx, "SELECT "+workspaceMemberColumns+" FROM workspace_member WHERE member_id = ?",
+		memberID.Hex()))
+}
+
+func (s *sqliteRepo) GetWorkspaceMemberByInviteToken(ctx context.Context, tokenHash string) (*model.WorkspaceMember, error) {
+	return scanWorkspaceMember(s.db.QueryRowContext(ctx, `SELECT `+workspaceMemberColumns+` FROM workspace_member
+		WHERE invite_token_hash = ? AND status = 'invited' LIMIT 1`,
+		tokenHash))
+}
+
+func (s *sqliteRepo) GetWorkspaceMembers(ctx context.Context, workspaceID primitive.ObjectID) ([]model.WorkspaceMember, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+workspaceMemberColumns+" FROM workspace_member WHERE workspace_id = ? ORDER BY member_id",
+		workspaceID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.WorkspaceMember{}
+	for rows.Next() {
+		member, err := scanWorkspaceMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *member)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *sqliteRepo) UpdateWorkspaceMember(ctx context.Context, member *model.WorkspaceMember) error {
+	// Replaces the whole row, like ReplaceOne; member_id is the first column.
+	values := workspaceMemberValues(member)
+	args := append([]interface{}{}, values[1:]...)
+	args = append(args, values[0])
+
+	return requireChange(s.db.ExecContext(ctx, `UPDATE workspace_member
+		SET workspace_id = ?, user_id = ?, email = ?, role = ?, status = ?, invite_token_hash = ?,
+			invited_by = ?, created_at = ?, joined_at = ?, expires_at = ?
+		WHERE member_id = ?`,
+		args...))
+}
+
+func (s *sqliteRepo) DeleteWorkspaceMember(ctx context.Context, memberID primitive.ObjectID) error {
+	return requireChange(s.db.ExecContext(ctx, "DELETE FROM workspace_member WHERE member_id = ?", memberID.Hex()))
+}
+
+const apiKeyColumns = "key_id, user_id, name, prefix, key_hash, scopes, created_at, last_used_at, revoked_at"
+
+func scanApiKey(row rowScanner) (*model.ApiKey, error) {
+	var key model.ApiKey
+	err := row.Scan(scanID(&key.KeyID), scanID(&key.UserID), &key.Name, &key.Prefix, &key.KeyHash,
+		scanJSON(&key.Scopes), scanTime(&key.CreatedAt), scanNullTime(&key.LastUsedAt), scanNullTime(&key.RevokedAt))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return &key, nil
+}
+
+func (s *sqliteRepo) InsertApiKey(ctx context.Context, key *model.ApiKey) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO api_key ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		key.KeyID.Hex(), key.UserID.Hex(), key.Name, key.Prefix, key.KeyHash, sqlStrings(key.Scopes),
+		sqlTime(key.CreatedAt), sqlNullTime(key.LastUsedAt), sqlNullTime(key.RevokedAt))
+	return sqliteError(err)
+}
+
+func (s *sqliteRepo) GetApiKeyByHash(ctx context.Context, keyHash string) (*model.ApiKey, error) {
+	return scanApiKey(s.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_key WHERE key_hash = ?", keyHash))
+}
+
+func (s *sqliteRepo) GetApiKeysByUser(ctx context.Context, userID primitive.ObjectID) ([]model.ApiKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_key WHERE user_id = ? ORDER BY key_id", userID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.ApiKey{}
+	for rows.Next() {
+		key, err := scanApiKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *key)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *sqliteRepo) RevokeApiKey(ctx context.Context, keyID primitive.ObjectID, userID primitive.ObjectID, at time.Time) error {
+	return requireChange(s.db.ExecContext(ctx,
+		"UPDATE api_key SET revoked_at = ? WHERE key_id = ? AND user_id = ? AND revoked_at IS NULL",
+		sqlTime(at), keyID.Hex(), userID.Hex()))
+}
+
+func (s *sqliteRepo) TouchApiKey(ctx context.Context, keyID primitive.ObjectID, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE api_key SET last_used_at = ? WHERE key_id = ?", sqlTime(at), keyID.Hex())
+	return err
+}
+
+const blockRuleColumns = "rule_id, type, pattern, action, reason, created_at"
+
+func (s *sqliteRepo) GetBlockRules(ctx context.Context) ([]model.BlockRule, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+blockRuleColumns+" FROM block_rule ORDER BY rule_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.BlockRule{}
+	for rows.Next() {
+		var rule model.BlockRule
+		err := rows.Scan(scanID(&rule.RuleID), &rule.Type, &rule.Pattern, &rule.Action, &rule.Reason, scanTime(&rule.CreatedAt))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, rule)
+	}
+
+	return res, rows.Err()
+}
+
+func (s *sqliteRepo) InsertBlockRule(ctx context.Context, rule *model.BlockRule) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO block_rule ("+blockRuleColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		rule.RuleID.Hex(), rule.Type, rule.Pattern, rule.Action, rule.Reason, sqlTime(rule.CreatedAt))
+	return sqliteError(err)
+}
+
+func (s *sqliteRepo) DeleteBlockRule(ctx context.Context, ruleID primitive.ObjectID) error {
+	return requireChange(s.db.ExecContext(ctx, "DELETE FROM block_rule WHERE rule_id = ?", ruleID.Hex()))
+}
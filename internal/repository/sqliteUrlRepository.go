@@ -0,0 +1,435 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const urlColumns = `url_id, user_id, workspace_id, label, long_url, short_url_key, domain, redirect_type,
+	expires_at, max_clicks, fallback_url, expired, protected, password_hash, disabled, deleted_at,
+	tags, no_of_clicks, device, location, created_at, updated_at`
+
+func scanUrl(row rowScanner) (*model.Url, error) {
+	var url model.Url
+	err := row.Scan(scanID(&url.UrlID), scanID(&url.UserID), scanNullID(&url.WorkspaceID), &url.Label,
+		&url.LongURL, &url.ShortURLKey, &url.Domain, &url.RedirectType, scanNullTime(&url.ExpiresAt),
+		&url.MaxClicks, &url.FallbackURL, &url.Expired, &url.Protected, &url.PasswordHash, &url.Disabled,
+		scanNullTime(&url.DeletedAt), scanJSON(&url.Tags), &url.NoOfClicks, scanJSON(&url.Device),
+		scanJSON(&url.Location), scanTime(&url.CreatedAt), scanTime(&url.UpdatedAt))
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	if len(url.Tags) == 0 {
+		url.Tags = nil
+	}
+
+	return &url, nil
+}
+
+func insertUrl(ctx context.Context, exec sqlExecer, url *model.Url) error {
+	_, err := exec.ExecContext(ctx, "INSERT INTO url ("+urlColumns+`)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		url.UrlID.Hex(), url.UserID.Hex(), sqlNullID(url.WorkspaceID), url.Label, url.LongURL,
+		url.ShortURLKey, url.Domain, url.RedirectType, sqlNullTime(url.ExpiresAt), url.MaxClicks,
+		url.FallbackURL, url.Expired, url.Protected, url.PasswordHash, url.Disabled,
+		sqlNullTime(url.DeletedAt), sqlStrings(url.Tags), url.NoOfClicks, sqlCounts(url.Device),
+		sqlCounts(url.Location), sqlTime(url.CreatedAt), sqlNullTime(&url.UpdatedAt))
+	return err
+}
+
+func (s *sqliteRepo) CheckUniqueUrlKey(ctx context.Context, domain string, key string) (int64, error) {
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM url WHERE domain = ? AND short_url_key = ?", domain, key).Scan(&count)
+	return count, err
+}
+
+func (s *sqliteRepo) InsertUrl(ctx context.Context, url *model.Url) error {
+	return sqliteError(insertUrl(ctx, s.db, url))
+}
+
+// InsertUrls stores every link or, if any of them clashes, none.
+func (s *sqliteRepo) InsertUrls(ctx context.Context, urls []*model.Url) error {
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		for _, url := range urls {
+			if err := insertUrl(ctx, tx, url); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteRepo) EachURL(ctx context.Context, userID primitive.ObjectID, fn func(*model.Url) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+urlColumns+` FROM url
+		WHERE user_id = ? AND workspace_id IS NULL AND deleted_at IS NULL
+		ORDER BY url_id`,
+		userID.Hex())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		url, err := scanUrl(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(url); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (s *sqliteRepo) GetAllURLs(ctx context.Context, userID primitive.ObjectID, query *model.ListUrlsQuery) ([]model.Url, error) {
+	field := urlSortFields[query.Sort]
+	if field == "" {
+		field = "created_at"
+	}
+	dir, op := "DESC", "<"
+	if query.Order == "asc" {
+		dir, op = "ASC", ">"
+	}
+
+	where, args := urlListWhere(userID, query)
+
+	if query.After != nil {
+		var value interface{}
+		switch query.Sort {
+		case "clicks":
+			value = query.After.Clicks
+		case "label":
+			value = query.After.Label
+		default:
+			value = sqlTime(query.After.CreatedAt)
+		}
+
+		where = append(where, "("+field+" "+op+" ? OR ("+field+" = ? AND url_id "+op+" ?))")
+		args = append(args, value, value, query.After.ID.Hex())
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+urlColumns+" FROM url WHERE "+strings.Join(where, " AND ")+
+		" ORDER BY "+field+" "+dir+", url_id "+dir+" LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.Url{}
+	for rows.Next() {
+		url, err := scanUrl(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *url)
+	}
+
+	return res, rows.Err()
+}
+
+// urlListWhere is urlListFilter as SQL conditions to be joined with AND.
+func urlListWhere(userID primitive.ObjectID, query *model.ListUrlsQuery) ([]string, []interface{}) {
+	// Personal listings leave out links the user created in a workspace.
+	where := []string{"user_id = ?", "workspace_id IS NULL", "deleted_at IS NULL"}
+	args := []interface{}{userID.Hex()}
+	if query.Workspace != nil {
+		where = []string{"workspace_id = ?", "deleted_at IS NULL"}
+		args = []interface{}{query.Workspace.Hex()}
+	}
+
+	if query.Label != "" {
+		where = append(where, "instr(lower(label), lower(?)) > 0")
+		args = append(args, query.Label)
+	}
+
+	if query.Domain != "" {
+		where = append(where, "long_url REGEXP ?")
+		args = append(args, "(?i)"+longURLDomainPattern(query.Domain))
+	}
+
+	if !query.CreatedFrom.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, sqlTime(query.CreatedFrom))
+	}
+	if !query.CreatedTo.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, sqlTime(query.CreatedTo))
+	}
+
+	// expires_at is NULL for links that never expire; keep the comparison
+	// from turning the whole condition NULL.
+	expired := "(expired = 1 OR (expires_at IS NOT NULL AND expires_at <= ?) OR (max_clicks > 0 AND no_of_clicks >= max_clicks))"
+	now := sqlTime(time.Now())
+
+	switch query.Status {
+	case "enabled":
+		where = append(where, "disabled = 0")
+	case "disabled":
+		where = append(where, "disabled = 1")
+	case "expired":
+		where = append(where, expired)
+		args = append(args, now)
+	case "active":
+		where = append(where, "disabled = 0", "NOT "+expired)
+		args = append(args, now)
+	}
+
+	return where, args
+}
+
+func (s *sqliteRepo) GetUrlByKey(ctx context.Context, domain string, key string) (*model.Url, error) {
+	return scanUrl(s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE domain = ? AND short_url_key = ?", domain, key))
+}
+
+func (s *sqliteRepo) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE url SET expired = 1
+		WHERE expired = 0 AND ((expires_at IS NOT NULL AND expires_at <= ?) OR (max_clicks > 0 AND no_of_clicks >= max_clicks))`,
+		sqlTime(now))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *sqliteRepo) GetUrlByID(ctx context.Context, urlID primitive.ObjectID) (*model.Url, error) {
+	return scanUrl(s.db.QueryRowContext(ctx, "SELECT "+urlColumns+" FROM url WHERE url_id = ?", urlID.Hex()))
+}
+
+// UpdateUrl saves the editable fields of url. Click counters are left alone
+// so concurrent redirects are not lost.
+func (s *sqliteRepo) UpdateUrl(ctx context.Context, url *model.Url) error {
+	return requireChange(s.db.ExecContext(ctx, `UPDATE url
+		SET label = ?, long_url = ?, redirect_type = ?, expires_at = ?, max_clicks = ?, fallback_url = ?,
+			expired = ?, protected = ?, password_hash = ?, disabled = ?, updated_at = ?
+		WHERE url_id = ? AND deleted_at IS NULL`,
+		url.Label, url.LongURL, url.RedirectType, sqlNullTime(url.ExpiresAt), url.MaxClicks, url.FallbackURL,
+		url.Expired, url.Protected, url.PasswordHash, url.Disabled, sqlNullTime(&url.UpdatedAt),
+		url.UrlID.Hex()))
+}
+
+func (s *sqliteRepo) DeleteUrl(ctx context.Context, urlID primitive.ObjectID, at time.Time) error {
+	return requireChange(s.db.ExecContext(ctx, "UPDATE url SET deleted_at = ? WHERE url_id = ? AND deleted_at IS NULL",
+		sqlTime(at), urlID.Hex()))
+}
+
+func (s *sqliteRepo) TakeClick(ctx context.Context, urlID primitive.ObjectID) error {
+	return requireChange(s.db.ExecContext(ctx, `UPDATE url SET no_of_clicks = no_of_clicks + 1
+		WHERE url_id = ? AND deleted_at IS NULL AND no_of_clicks < max_clicks`, urlID.Hex()))
+}
+
+// countClick bumps the link's counters in one statement. The breakdowns are
+// JSON objects; json_each finds the current count for any key, which a
+// JSON path could not express for names containing quotes or dots. ?5 is 0
+// for clicks the redirect already counted.
+const countClick = `UPDATE url SET
+	no_of_clicks = no_of_clicks + ?5,
+	device = json_patch(device, json_object(?1, COALESCE((SELECT value FROM json_each(url.device) WHERE key = ?1), 0) + 1)),
+	location = json_patch(location, json_object(?2, COALESCE((SELECT value FROM json_each(url.location) WHERE key = ?2), 0) + 1))
+	WHERE domain = ?3 AND short_url_key = ?4`
+
+const clickColumns = `click_id, key, domain, at, ip_hash, country, city, os, browser, device_type, referrer,
+	utm_source, utm_medium, utm_campaign, utm_term, utm_content`
+
+func (s *sqliteRepo) RecordClicks(ctx context.Context, clicks []*model.ClickEvent) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	return s.withTx(ctx, func(tx *sql.Tx) error {
+		counters, err := tx.PrepareContext(ctx, countClick)
+		if err != nil {
+			return err
+		}
+		defer counters.Close()
+
+		events, err := tx.PrepareContext(ctx, "INSERT INTO click ("+clickColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			return err
+		}
+		defer events.Close()
+
+		for _, click := range clicks {
+			count := 1
+			if click.Counted {
+				count = 0
+			}
+
+			if _, err := counters.ExecContext(ctx, click.OS, click.City, click.Domain, click.Key, count); err != nil {
+				return err
+			}
+
+			_, err := events.ExecContext(ctx, click.ID.Hex(), click.Key, click.Domain, sqlTime(click.At),
+				click.IPHash, click.Country, click.City, click.OS, click.Browser, click.DeviceType, click.Referrer,
+				click.UTM.Source, click.UTM.Medium, click.UTM.Campaign, click.UTM.Term, click.UTM.Content)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func scanClick(row rowScanner) (*model.ClickEvent, error) {
+	var event model.ClickEvent
+	err := row.Scan(scanID(&event.ID), &event.Key, &event.Domain, scanTime(&event.At), &event.IPHash,
+		&event.Country, &event.City, &event.OS, &event.Browser, &event.DeviceType, &event.Referrer,
+		&event.UTM.Source, &event.UTM.Medium, &event.UTM.Campaign, &event.UTM.Term, &event.UTM.Content)
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return &event, nil
+}
+
+func (s *sqliteRepo) FindClickEvents(ctx context.Context, filter *model.ClickEventFilter) ([]model.ClickEvent, error) {
+	where, args := clickWhere(filter)
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+clickColumns+" FROM click WHERE "+where+" ORDER BY click_id DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := []model.ClickEvent{}
+	for rows.Next() {
+		event, err := scanClick(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, *event)
+	}
+
+	return res, rows.Err()
+}
+
+// AggregateClicks runs one GROUP BY per breakdown, in a single transaction
+// so they all count the same events.
+func (s *sqliteRepo) AggregateClicks(ctx context.Context, filter *model.ClickEventFilter, bucket time.Duration) (*model.ClickAggregate, error) {
+	where, args := clickWhere(filter)
+	agg := model.NewClickAggregate()
+
+	err := s.withTx(ctx, func(tx *sql.Tx) error {
+		bucketArgs := append([]interface{}{bucket.Milliseconds()}, args...)
+		rows, err := tx.QueryContext(ctx, "SELECT at - at % ?, COUNT(*) FROM click WHERE "+where+" GROUP BY 1", bucketArgs...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var start int64
+			var n int
+			if err := rows.Scan(&start, &n); err != nil {
+				rows.Close()
+				return err
+			}
+			agg.Buckets[time.UnixMilli(start).UTC()] += n
+			agg.Total += n
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		breakdowns := []struct {
+			column string
+			into   map[string]int
+		}{
+			{"country", agg.Countries},
+			{"city", agg.Cities},
+			{"os", agg.OS},
+			{"browser", agg.Browsers},
+			{"referrer", agg.Referrers},
+		}
+		for _, breakdown := range breakdowns {
+			rows, err := tx.QueryContext(ctx, "SELECT "+breakdown.column+", COUNT(*) FROM click WHERE "+where+" GROUP BY 1", args...)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var value string
+				var n int
+				if err := rows.Scan(&value, &n); err != nil {
+					rows.Close()
+					return err
+				}
+				breakdown.into[value] += n
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return agg, nil
+}
+
+// clickWhere is clickFilter as an SQL condition.
+func clickWhere(filter *model.ClickEventFilter) (string, []interface{}) {
+	where := []string{"key = ?", "domain = ?"}
+	args := []interface{}{filter.Key, filter.Domain}
+
+	if !filter.From.IsZero() {
+		where = append(where, "at >= ?")
+		args = append(args, sqlTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "at < ?")
+		args = append(args, sqlTime(filter.To))
+	}
+
+	if !filter.Before.IsZero() {
+		where = append(where, "click_id < ?")
+		args = append(args, filter.Before.Hex())
+	}
+
+	equal := [][2]string{
+		{"country", filter.Country},
+		{"city", filter.City},
+		{"os", filter.OS},
+		{"browser", filter.Browser},
+		{"device_type", filter.DeviceType},
+		{"utm_source", filter.UTMSource},
+		{"utm_medium", filter.UTMMedium},
+		{"utm_campaign", filter.UTMCampaign},
+	}
+	for _, pair := range equal {
+		if pair[1] != "" {
+			where = append(where, pair[0]+" = ?")
+			args = append(args, pair[1])
+		}
+	}
+
+	if filter.Referrer != "" {
+		where = append(where, "instr(lower(referrer), lower(?)) > 0")
+		args = append(args, filter.Referrer)
+	}
+
+	return strings.Join(where, " AND "), args
+}
@@ -2,6 +2,8 @@ package repository
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 	"time"
 
 	"example.com/url-shortener/internal/model"
@@ -23,6 +25,14 @@ func NewUserRepository(db *mongo.Database) model.UserRepositoryInterface {
 
 func (u *userRepo) Signup(ctx context.Context, user *model.User) error {
 	_, err := u.db.Collection("user").InsertOne(ctx, user)
+	return duplicateError(err)
+}
+
+// duplicateError reports unique index violations as model.ErrDuplicate.
+func duplicateError(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %v", model.ErrDuplicate, err)
+	}
 	return err
 }
 
@@ -36,30 +46,185 @@ func (u *userRepo) GetUserByEmail(ctx context.Context, email string) (*model.Use
 	return &user, err
 }
 
-func (u *userRepo) CheckUniqueUrlKey(ctx context.Context, key string) (int64, error) {
-	return u.db.Collection("url").CountDocuments(ctx, bson.M{"short_url_key": key})
+func (u *userRepo) CheckUniqueUrlKey(ctx context.Context, domain string, key string) (int64, error) {
+	return u.db.Collection("url").CountDocuments(ctx, urlKeyFilter(domain, key))
+}
+
+// urlKeyFilter matches key on domain. Links on the default domain were
+// stored before domains existed and have no domain field at all.
+func urlKeyFilter(domain string, key string) bson.M {
+	if domain == "" {
+		return bson.M{"short_url_key": key, "domain": bson.M{"$in": bson.A{nil, ""}}}
+	}
+	return bson.M{"short_url_key": key, "domain": domain}
 }
 
 func (u *userRepo) InsertUrl(ctx context.Context, url *model.Url) error {
 	_, err := u.db.Collection("url").InsertOne(ctx, url)
-	return err
+	return duplicateError(err)
+}
+
+// InsertUrls stores every link or, if anything goes wrong, none. Without a
+// transaction that means taking back the ones that did go in; they were
+// never handed out, so nobody can be relying on them yet. Every id in the
+// batch is new, so deleting all of them only removes what this call wrote.
+func (u *userRepo) InsertUrls(ctx context.Context, urls []*model.Url) error {
+	docs := make([]interface{}, len(urls))
+	ids := make(bson.A, len(urls))
+	for i, url := range urls {
+		docs[i] = url
+		ids[i] = url.UrlID
+	}
+
+	_, err := u.db.Collection("url").InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return nil
+	}
+
+	// ctx may be what failed the insert, so the cleanup gets its own.
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if _, delErr := u.db.Collection("url").DeleteMany(cleanupCtx, bson.M{"_id": bson.M{"$in": ids}}); delErr != nil {
+		return fmt.Errorf("%v (and removing the links already inserted failed: %v)", err, delErr)
+	}
+
+	return duplicateError(err)
+}
+
+func (u *userRepo) EachURL(ctx context.Context, userID primitive.ObjectID, fn func(*model.Url) error) error {
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+
+	cursor, err := u.db.Collection("url").Find(ctx, bson.M{"user_id": userID, "workspace_id": nil, "deleted_at": nil}, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var url model.Url
+		if err := cursor.Decode(&url); err != nil {
+			return err
+		}
+		if err := fn(&url); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+var urlSortFields = map[string]string{
+	"created_at": "created_at",
+	"clicks":     "no_of_clicks",
+	"label":      "label",
 }
 
-func (u *userRepo) GetAllURLs(ctx context.Context, userID primitive.ObjectID) (*[]model.Url, error) {
+func (u *userRepo) GetAllURLs(ctx context.Context, userID primitive.ObjectID, query *model.ListUrlsQuery) ([]model.Url, error) {
+	field := urlSortFields[query.Sort]
+	dir := -1
+	if query.Order == "asc" {
+		dir = 1
+	}
+
+	filter := urlListFilter(userID, query)
+
+	if query.After != nil {
+		var value interface{}
+		switch query.Sort {
+		case "clicks":
+			value = query.After.Clicks
+		case "label":
+			value = query.After.Label
+		default:
+			value = query.After.CreatedAt
+		}
+
+		op := "$lt"
+		if dir == 1 {
+			op = "$gt"
+		}
 
-	cursor, err := u.db.Collection("url").Find(ctx, bson.M{"user_id": userID})
+		filter = bson.M{"$and": bson.A{filter, bson.M{"$or": bson.A{
+			bson.M{field: bson.M{op: value}},
+			bson.M{field: value, "_id": bson.M{op: query.After.ID}},
+		}}}}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: field, Value: dir}, {Key: "_id", Value: dir}}).
+		SetLimit(int64(query.Limit))
+
+	cursor, err := u.db.Collection("url").Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	var res []model.Url
+	res := []model.Url{}
 
 	err = cursor.All(ctx, &res)
 	if err != nil {
 		return nil, err
 	}
 
-	return &res, nil
+	return res, nil
+}
+
+func urlListFilter(userID primitive.ObjectID, query *model.ListUrlsQuery) bson.M {
+	// Personal listings leave out links the user created in a workspace.
+	filter := bson.M{"user_id": userID, "workspace_id": nil, "deleted_at": nil}
+	if query.Workspace != nil {
+		filter = bson.M{"workspace_id": *query.Workspace, "deleted_at": nil}
+	}
+
+	if query.Label != "" {
+		filter["label"] = bson.M{"$regex": regexp.QuoteMeta(query.Label), "$options": "i"}
+	}
+
+	if query.Domain != "" {
+		filter["long_url"] = bson.M{"$regex": longURLDomainPattern(query.Domain), "$options": "i"}
+	}
+
+	created := bson.M{}
+	if !query.CreatedFrom.IsZero() {
+		created["$gte"] = query.CreatedFrom
+	}
+	if !query.CreatedTo.IsZero() {
+		created["$lt"] = query.CreatedTo
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	now := time.Now()
+	expired := bson.A{
+		bson.M{"expired": true},
+		bson.M{"expires_at": bson.M{"$lte": now}},
+		bson.M{"$expr": bson.M{"$and": bson.A{
+			bson.M{"$gt": bson.A{"$max_clicks", 0}},
+			bson.M{"$gte": bson.A{"$no_of_clicks", "$max_clicks"}},
+		}}},
+	}
+
+	switch query.Status {
+	case "enabled":
+		filter["disabled"] = bson.M{"$ne": true}
+	case "disabled":
+		filter["disabled"] = true
+	case "expired":
+		filter["$or"] = expired
+	case "active":
+		filter["disabled"] = bson.M{"$ne": true}
+		filter["$nor"] = expired
+	}
+
+	return filter
+}
+
+// longURLDomainPattern matches URLs whose host is domain or one of its
+// subdomains.
+func longURLDomainPattern(domain string) string {
+	return "^[a-zA-Z][a-zA-Z0-9+.-]*://([^/?#@]*@)?([^/?#]*\\.)?" + regexp.QuoteMeta(domain) + "(:[0-9]+)?([/?#]|$)"
 }
 
 func (u *userRepo) GetUserById(ctx context.Context, userID primitive.ObjectID) (*model.User, error) {
@@ -68,29 +233,87 @@ func (u *userRepo) GetUserById(ctx context.Context, userID primitive.ObjectID) (
 	return &user, err
 }
 
-func (u *userRepo) UpdateRefreshTokenInDB(ctx context.Context, userID primitive.ObjectID, refreshToken any, at time.Time) error {
-	_, err := u.db.Collection("user").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"refresh_token": refreshToken, "refresh_token_issued_at": at}})
-	return err
-}
-
-func (u *userRepo) UpdateUrlInfoInDB(ctx context.Context, key string, device string, location string) (*model.Url, error) {
+func (u *userRepo) GetUrlByKey(ctx context.Context, domain string, key string) (*model.Url, error) {
 	var url model.Url
+	err := u.db.Collection("url").FindOne(ctx, urlKeyFilter(domain, key)).Decode(&url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &url, nil
+}
 
-	filter := bson.M{"short_url_key": key}
-	update := bson.M{
-		"$inc": bson.M{
-			"no_of_clicks":         1,
-			"device." + device:     1,
-			"location." + location: 1,
+func (u *userRepo) ExpireURLs(ctx context.Context, now time.Time) (int64, error) {
+	filter := bson.M{
+		"expired": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lte": now}},
+			bson.M{"$expr": bson.M{"$and": bson.A{
+				bson.M{"$gt": bson.A{"$max_clicks", 0}},
+				bson.M{"$gte": bson.A{"$no_of_clicks", "$max_clicks"}},
+			}}},
 		},
 	}
 
-	options := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	res, err := u.db.Collection("url").UpdateMany(ctx, filter, bson.M{"$set": bson.M{"expired": true}})
+	if err != nil {
+		return 0, err
+	}
 
-	err := u.db.Collection("url").FindOneAndUpdate(ctx, filter, update, options).Decode(&url)
+	return res.ModifiedCount, nil
+}
+
+func (u *userRepo) GetUrlByID(ctx context.Context, urlID primitive.ObjectID) (*model.Url, error) {
+	var url model.Url
+	err := u.db.Collection("url").FindOne(ctx, bson.M{"_id": urlID}).Decode(&url)
 	if err != nil {
 		return nil, err
 	}
 
 	return &url, nil
 }
+
+// UpdateUrl saves the editable fields of url. Click counters are left alone
+// so concurrent redirects are not lost.
+func (u *userRepo) UpdateUrl(ctx context.Context, url *model.Url) error {
+	set := bson.M{
+		"label":         url.Label,
+		"long_url":      url.LongURL,
+		"redirect_type": url.RedirectType,
+		"max_clicks":    url.MaxClicks,
+		"fallback_url":  url.FallbackURL,
+		"expired":       url.Expired,
+		"protected":     url.Protected,
+		"password_hash": url.PasswordHash,
+		"disabled":      url.Disabled,
+		"updated_at":    url.UpdatedAt,
+	}
+	update := bson.M{"$set": set}
+	if url.ExpiresAt != nil {
+		set["expires_at"] = url.ExpiresAt
+	} else {
+		update["$unset"] = bson.M{"expires_at": ""}
+	}
+
+	res, err := u.db.Collection("url").UpdateOne(ctx, bson.M{"_id": url.UrlID, "deleted_at": nil}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) DeleteUrl(ctx context.Context, urlID primitive.ObjectID, at time.Time) error {
+	res, err := u.db.Collection("url").UpdateOne(ctx, bson.M{"_id": urlID, "deleted_at": nil}, bson.M{"$set": bson.M{"deleted_at": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
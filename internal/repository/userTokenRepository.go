@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (u *userRepo) MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, at time.Time) error {
+	update := bson.M{"$set": bson.M{"email_verified": true, "email_verified_at": at}}
+
+	res, err := u.db.Collection("user").UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) UpdatePassword(ctx context.Context, userID primitive.ObjectID, passwordHash string) error {
+	res, err := u.db.Collection("user").UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": passwordHash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) InsertUserToken(ctx context.Context, token *model.UserToken) error {
+	_, err := u.db.Collection("user_token").InsertOne(ctx, token)
+	return err
+}
+
+func (u *userRepo) UseUserToken(ctx context.Context, tokenHash string, purpose string, now time.Time) (*model.UserToken, error) {
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"purpose":    purpose,
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var token model.UserToken
+	err := u.db.Collection("user_token").FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"used_at": now}}, opts).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (u *userRepo) DeleteUserTokens(ctx context.Context, userID primitive.ObjectID, purpose string) error {
+	_, err := u.db.Collection("user_token").DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose})
+	return err
+}
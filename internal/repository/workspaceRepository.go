@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (u *userRepo) InsertWorkspace(ctx context.Context, workspace *model.Workspace) error {
+	_, err := u.db.Collection("workspace").InsertOne(ctx, workspace)
+	return err
+}
+
+func (u *userRepo) GetWorkspaceByID(ctx context.Context, workspaceID primitive.ObjectID) (*model.Workspace, error) {
+	var workspace model.Workspace
+	err := u.db.Collection("workspace").FindOne(ctx, bson.M{"_id": workspaceID}).Decode(&workspace)
+	if err != nil {
+		return nil, err
+	}
+
+	return &workspace, nil
+}
+
+// GetWorkspacesByUser returns the workspaces userID is an active member of.
+func (u *userRepo) GetWorkspacesByUser(ctx context.Context, userID primitive.ObjectID) ([]model.Workspace, error) {
+	ids, err := u.db.Collection("workspace_member").Distinct(ctx, "workspace_id", bson.M{"user_id": userID, "status": "active"})
+	if err != nil {
+		return nil, err
+	}
+
+	res := []model.Workspace{}
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	cursor, err := u.db.Collection("workspace").Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (u *userRepo) InsertWorkspaceMember(ctx context.Context, member *model.WorkspaceMember) error {
+	_, err := u.db.Collection("workspace_member").InsertOne(ctx, member)
+	return err
+}
+
+func (u *userRepo) GetWorkspaceMember(ctx context.Context, workspaceID primitive.ObjectID, userID primitive.ObjectID) (*model.WorkspaceMember, error) {
+	return u.findWorkspaceMember(ctx, bson.M{"workspace_id": workspaceID, "user_id": userID, "status": "active"})
+}
+
+func (u *userRepo) GetWorkspaceMemberByID(ctx context.Context, memberID primitive.ObjectID) (*model.WorkspaceMember, error) {
+	return u.findWorkspaceMember(ctx, bson.M{"_id": memberID})
+}
+
+func (u *userRepo) GetWorkspaceMemberByInviteToken(ctx context.Context, tokenHash string) (*model.WorkspaceMember, error) {
+	return u.findWorkspaceMember(ctx, bson.M{"invite_token_hash": tokenHash, "status": "invited"})
+}
+
+func (u *userRepo) findWorkspaceMember(ctx context.Context, filter bson.M) (*model.WorkspaceMember, error) {
+	var member model.WorkspaceMember
+	err := u.db.Collection("workspace_member").FindOne(ctx, filter).Decode(&member)
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+func (u *userRepo) GetWorkspaceMembers(ctx context.Context, workspaceID primitive.ObjectID) ([]model.WorkspaceMember, error) {
+	cursor, err := u.db.Collection("workspace_member").Find(ctx, bson.M{"workspace_id": workspaceID})
+	if err != nil {
+		return nil, err
+	}
+
+	res := []model.WorkspaceMember{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (u *userRepo) UpdateWorkspaceMember(ctx context.Context, member *model.WorkspaceMember) error {
+	res, err := u.db.Collection("workspace_member").ReplaceOne(ctx, bson.M{"_id": member.MemberID}, member)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+func (u *userRepo) DeleteWorkspaceMember(ctx context.Context, memberID primitive.ObjectID) error {
+	res, err := u.db.Collection("workspace_member").DeleteOne(ctx, bson.M{"_id": memberID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
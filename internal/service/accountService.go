@@ -0,0 +1,230 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	resetPasswordTTL = time.Hour
+
+	defaultAppURL = "https://reago.netlify.app"
+)
+
+// appURL is where the links in account emails point, from APP_URL.
+func appURL() string {
+	if u := os.Getenv("APP_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return defaultAppURL
+}
+
+// requireVerifiedEmail reports whether REQUIRE_EMAIL_VERIFICATION keeps
+// unverified users from signing in. It is off by default so accounts made
+// before verification existed keep working.
+func requireVerifiedEmail() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+func (u *userServ) SendEmailVerification(c context.Context, userID string) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	user, err := u.repository.GetUserById(ctx, uid)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if user.EmailVerified {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "email already verified"}
+	}
+
+	return u.sendEmailVerification(ctx, user)
+}
+
+func (u *userServ) sendEmailVerification(ctx context.Context, user *model.User) error {
+	token, err := u.issueUserToken(ctx, user.UserID, model.TokenVerifyEmail, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+
+	u.sendMail(&Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link:\n\n%s/verify-email?token=%s\n\nThe link expires in 24 hours.\n",
+			user.FullName, appURL(), url.QueryEscape(token)),
+	})
+
+	return nil
+}
+
+func (u *userServ) VerifyEmail(c context.Context, req *model.VerifyEmailReq) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	now := time.Now()
+
+	token, err := u.useUserToken(ctx, req.Token, model.TokenVerifyEmail, now)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.MarkEmailVerified(ctx, token.UserID, now)
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "invalid or expired token"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if err := u.repository.DeleteUserTokens(ctx, token.UserID, model.TokenVerifyEmail); err != nil {
+		log.Printf("failed to clear verification tokens of %s: %v", token.UserID.Hex(), err)
+	}
+
+	return nil
+}
+
+// ForgotPassword mails a reset link if an account uses req.Email. It
+// answers the same either way so it can't be used to find accounts. Requests
+// are limited per address and per client so it can't be used to flood an
+// inbox either.
+func (u *userServ) ForgotPassword(c context.Context, req *model.ForgotPasswordReq) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	if !u.resets.Reserve("ip:"+req.IP) || !u.resets.Reserve("email:"+strings.ToLower(strings.TrimSpace(req.Email))) {
+		return &utils.AppError{Code: http.StatusTooManyRequests, Message: "too many reset requests, try again later"}
+	}
+
+	user, err := u.repository.GetUserByEmail(ctx, req.Email)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	token, err := u.issueUserToken(ctx, user.UserID, model.TokenResetPassword, resetPasswordTTL)
+	if err != nil {
+		return err
+	}
+
+	u.sendMail(&Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. If it was you, open this link:\n\n%s/reset-password?token=%s\n\nThe link expires in 1 hour. If it wasn't you, ignore this email.\n",
+			user.FullName, appURL(), url.QueryEscape(token)),
+	})
+
+	return nil
+}
+
+// ResetPassword sets a new password and signs the user out everywhere.
+func (u *userServ) ResetPassword(c context.Context, req *model.ResetPasswordReq) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	if req.Password == "" {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "password is required"}
+	}
+
+	now := time.Now()
+
+	token, err := u.useUserToken(ctx, req.Token, model.TokenResetPassword, now)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := utils.HashPassword(req.Password)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	err = u.repository.UpdatePassword(ctx, token.UserID, hashedPassword)
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "invalid or expired token"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if err := u.repository.DeleteUserTokens(ctx, token.UserID, model.TokenResetPassword); err != nil {
+		log.Printf("failed to clear reset tokens of %s: %v", token.UserID.Hex(), err)
+	}
+
+	err = u.repository.RevokeSessionsByUser(ctx, token.UserID, now)
+	u.sessions.forgetUser(token.UserID.Hex())
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+func (u *userServ) issueUserToken(ctx context.Context, userID primitive.ObjectID, purpose string, ttl time.Duration) (string, error) {
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	now := time.Now()
+	err = u.repository.InsertUserToken(ctx, &model.UserToken{
+		TokenID:   primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: utils.HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	})
+	if err != nil {
+		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return token, nil
+}
+
+func (u *userServ) useUserToken(ctx context.Context, token string, purpose string, now time.Time) (*model.UserToken, error) {
+	if token == "" {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid or expired token"}
+	}
+
+	res, err := u.repository.UseUserToken(ctx, utils.HashToken(token), purpose, now)
+	if err == mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid or expired token"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return res, nil
+}
+
+// sendMail sends msg in the background, so a slow mail server neither holds
+// up the request nor reveals through timing whether an account exists.
+func (u *userServ) sendMail(msg *Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := u.mailer.Send(ctx, msg); err != nil {
+			log.Printf("failed to send %q to %s: %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
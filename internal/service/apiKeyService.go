@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var apiKeyScopes = map[string]bool{
+	model.ScopeLinksRead:  true,
+	model.ScopeLinksWrite: true,
+	model.ScopeStatsRead:  true,
+}
+
+// touchApiKeyEvery limits how often last_used_at is written for a busy key.
+const touchApiKeyEvery = time.Minute
+
+func (u *userServ) CreateApiKey(c context.Context, userID string, req *model.CreateApiKeyReq) (*model.CreateApiKeyRes, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "name is required"}
+	}
+
+	if len(req.Scopes) == 0 {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "at least one scope is required"}
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	seen := make(map[string]bool)
+	for _, scope := range req.Scopes {
+		if !apiKeyScopes[scope] {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "scopes must be links:read, links:write or stats:read"}
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+	secret := model.ApiKeyPrefix + token
+
+	key := &model.ApiKey{
+		KeyID:     primitive.NewObjectID(),
+		UserID:    uid,
+		Name:      name,
+		Prefix:    secret[:len(model.ApiKeyPrefix)+6],
+		KeyHash:   utils.HashToken(secret),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	if err := u.repository.InsertApiKey(ctx, key); err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return &model.CreateApiKeyRes{ApiKey: *key, Key: secret}, nil
+}
+
+func (u *userServ) ListApiKeys(c context.Context, userID string) ([]model.ApiKey, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	res, err := u.repository.GetApiKeysByUser(ctx, uid)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return res, nil
+}
+
+func (u *userServ) RevokeApiKey(c context.Context, userID string, keyID string) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	id, err := primitive.ObjectIDFromHex(keyID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "invalid key id"}
+	}
+
+	err = u.repository.RevokeApiKey(ctx, id, uid, time.Now())
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusNotFound, Message: "api key not found"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+// AuthenticateApiKey returns the live key matching key and records its use.
+func (u *userServ) AuthenticateApiKey(c context.Context, key string) (*model.ApiKey, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	if !strings.HasPrefix(key, model.ApiKeyPrefix) {
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "invalid api key"}
+	}
+
+	apiKey, err := u.repository.GetApiKeyByHash(ctx, utils.HashToken(key))
+	if err == mongo.ErrNoDocuments || (err == nil && apiKey.RevokedAt != nil) {
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "invalid api key"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= touchApiKeyEvery {
+		if err := u.repository.TouchApiKey(ctx, apiKey.KeyID, now); err != nil {
+			log.Printf("failed to record use of api key %s: %v", apiKey.KeyID.Hex(), err)
+		}
+		apiKey.LastUsedAt = &now
+	}
+
+	return apiKey, nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// attemptLimiter counts failed attempts per key within a fixed window.
+type attemptLimiter struct {
+	max    int
+	window time.Duration
+
+	mu        sync.Mutex
+	attempts  map[string]*attemptWindow
+	lastSweep time.Time
+}
+
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[string]*attemptWindow),
+	}
+}
+
+// Reserve counts an attempt for key and reports whether it was within the
+// limit. Counting before the attempt is checked, rather than after it
+// fails, keeps concurrent attempts from all slipping through.
+func (a *attemptLimiter) Reserve(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.sweep(now)
+
+	w := a.current(key, now)
+	if w == nil {
+		w = &attemptWindow{start: now}
+		a.attempts[key] = w
+	}
+	if w.count >= a.max {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// Refund gives back an attempt that succeeded.
+func (a *attemptLimiter) Refund(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if w := a.current(key, time.Now()); w != nil && w.count > 0 {
+		w.count--
+	}
+}
+
+func (a *attemptLimiter) current(key string, now time.Time) *attemptWindow {
+	w, ok := a.attempts[key]
+	if !ok || now.Sub(w.start) >= a.window {
+		return nil
+	}
+	return w
+}
+
+// sweep drops finished windows so the map does not grow without bound.
+func (a *attemptLimiter) sweep(now time.Time) {
+	if now.Sub(a.lastSweep) < a.window {
+		return
+	}
+	a.lastSweep = now
+
+	for key, w := range a.attempts {
+		if now.Sub(w.start) >= a.window {
+			delete(a.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BlockRuleSource is where blocklist rules are kept.
+type BlockRuleSource interface {
+	Load(ctx context.Context) ([]model.BlockRule, error)
+	Add(ctx context.Context, rule *model.BlockRule) error
+	Remove(ctx context.Context, ruleID primitive.ObjectID) error
+}
+
+var errRuleNotFound = errors.New("blocklist: rule not found")
+
+type compiledRule struct {
+	rule  model.BlockRule
+	regex *regexp.Regexp
+}
+
+// Blocklist matches destination URLs against rules loaded from a
+// BlockRuleSource. Rules are swapped atomically on every reload, so lookups
+// never wait on the source.
+type Blocklist struct {
+	source BlockRuleSource
+
+	mu    sync.RWMutex
+	rules []compiledRule
+}
+
+func NewBlocklist(source BlockRuleSource) *Blocklist {
+	return &Blocklist{source: source}
+}
+
+// NewBlocklistFromEnv picks the rule source from BLOCKLIST_SOURCE: "file"
+// reads the JSON file at BLOCKLIST_FILE, "db" uses the blocklist collection
+// and "none" (the default) disables blocking. Rules are loaded once before
+// returning.
+func NewBlocklistFromEnv(repository model.UserRepositoryInterface) (*Blocklist, error) {
+	var source BlockRuleSource
+
+	switch os.Getenv("BLOCKLIST_SOURCE") {
+	case "file":
+		source = &fileRuleSource{path: os.Getenv("BLOCKLIST_FILE")}
+	case "db":
+		source = &repositoryRuleSource{repository}
+	case "none", "":
+		source = &memoryRuleSource{}
+	default:
+		return nil, fmt.Errorf("blocklist: unknown source %q", os.Getenv("BLOCKLIST_SOURCE"))
+	}
+
+	b := NewBlocklist(source)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := b.Reload(ctx); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Start reloads the rules every BLOCKLIST_RELOAD_INTERVAL (default 30s)
+// until ctx is cancelled, so edits made elsewhere are picked up.
+func (b *Blocklist) Start(ctx context.Context) {
+	interval, err := time.ParseDuration(os.Getenv("BLOCKLIST_RELOAD_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reloadCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				if err := b.Reload(reloadCtx); err != nil {
+					log.Printf("blocklist reload failed, keeping previous rules: %v", err)
+				}
+				cancel()
+			}
+		}
+	}()
+}
+
+func (b *Blocklist) Reload(ctx context.Context) error {
+	rules, err := b.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c, err := compileRule(rule)
+		if err != nil {
+			log.Printf("skipping blocklist rule %s: %v", rule.RuleID.Hex(), err)
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+
+	b.mu.Lock()
+	b.rules = compiled
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *Blocklist) Rules() []model.BlockRule {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	rules := make([]model.BlockRule, len(b.rules))
+	for i, c := range b.rules {
+		rules[i] = c.rule
+	}
+	return rules
+}
+
+func (b *Blocklist) Add(ctx context.Context, rule *model.BlockRule) error {
+	if _, err := compileRule(*rule); err != nil {
+		return err
+	}
+
+	if err := b.source.Add(ctx, rule); err != nil {
+		return err
+	}
+
+	return b.Reload(ctx)
+}
+
+func (b *Blocklist) Remove(ctx context.Context, ruleID primitive.ObjectID) error {
+	if err := b.source.Remove(ctx, ruleID); err != nil {
+		return err
+	}
+
+	return b.Reload(ctx)
+}
+
+// Match returns the rule that applies to rawURL, preferring block rules
+// over flag rules, or nil.
+func (b *Blocklist) Match(rawURL string) *model.BlockRule {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var match *model.BlockRule
+	for i := range b.rules {
+		c := &b.rules[i]
+		if !c.matches(host, rawURL) {
+			continue
+		}
+		if c.rule.Action == "block" {
+			return &c.rule
+		}
+		if match == nil {
+			match = &c.rule
+		}
+	}
+
+	return match
+}
+
+func (c *compiledRule) matches(host string, rawURL string) bool {
+	switch c.rule.Type {
+	case "domain":
+		return host == c.rule.Pattern || strings.HasSuffix(host, "."+c.rule.Pattern)
+	case "suffix":
+		return strings.HasSuffix(host, c.rule.Pattern)
+	case "regex":
+		return c.regex.MatchString(rawURL)
+	}
+	return false
+}
+
+func compileRule(rule model.BlockRule) (compiledRule, error) {
+	c := compiledRule{rule: rule}
+
+	if rule.Action != "block" && rule.Action != "flag" {
+		return c, fmt.Errorf("action must be block or flag")
+	}
+
+	if rule.Pattern == "" {
+		return c, fmt.Errorf("pattern is required")
+	}
+
+	switch rule.Type {
+	case "domain", "suffix":
+		c.rule.Pattern = strings.TrimSuffix(strings.ToLower(rule.Pattern), ".")
+	case "regex":
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return c, fmt.Errorf("invalid regex: %v", err)
+		}
+		c.regex = re
+	default:
+		return c, fmt.Errorf("type must be domain, suffix or regex")
+	}
+
+	return c, nil
+}
+
+// repositoryRuleSource keeps rules in the database, shared by every
+// instance.
+type repositoryRuleSource struct {
+	repository model.UserRepositoryInterface
+}
+
+func (r *repositoryRuleSource) Load(ctx context.Context) ([]model.BlockRule, error) {
+	return r.repository.GetBlockRules(ctx)
+}
+
+func (r *repositoryRuleSource) Add(ctx context.Context, rule *model.BlockRule) error {
+	return r.repository.InsertBlockRule(ctx, rule)
+}
+
+func (r *repositoryRuleSource) Remove(ctx context.Context, ruleID primitive.ObjectID) error {
+	err := r.repository.DeleteBlockRule(ctx, ruleID)
+	if err == mongo.ErrNoDocuments {
+		return errRuleNotFound
+	}
+	return err
+}
+
+// fileRuleSource keeps rules as a JSON array in a local file. The file is
+// only re-read when its modification time changes, and rules added through
+// the API are written back to it.
+type fileRuleSource struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	rules   []model.BlockRule
+}
+
+func (f *fileRuleSource) Load(ctx context.Context) ([]model.BlockRule, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	return f.rules, nil
+}
+
+func (f *fileRuleSource) load() error {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		f.rules, f.modTime = nil, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	var rules []model.BlockRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("blocklist: %s: %w", f.path, err)
+	}
+
+	f.rules, f.modTime = rules, info.ModTime()
+	return nil
+}
+
+func (f *fileRuleSource) Add(ctx context.Context, rule *model.BlockRule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Pick up edits made to the file since the last reload first.
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	rules := append(append([]model.BlockRule{}, f.rules...), *rule)
+	return f.write(rules)
+}
+
+func (f *fileRuleSource) Remove(ctx context.Context, ruleID primitive.ObjectID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.load(); err != nil {
+		return err
+	}
+
+	rules := make([]model.BlockRule, 0, len(f.rules))
+	for _, rule := range f.rules {
+		if rule.RuleID != ruleID {
+			rules = append(rules, rule)
+		}
+	}
+
+	if len(rules) == len(f.rules) {
+		return errRuleNotFound
+	}
+
+	return f.write(rules)
+}
+
+// write replaces the file atomically so a concurrent reader never sees a
+// half-written list.
+func (f *fileRuleSource) write(rules []model.BlockRule) error {
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".blocklist-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+
+	f.rules = rules
+	if info, err := os.Stat(f.path); err == nil {
+		f.modTime = info.ModTime()
+	}
+
+	return nil
+}
+
+// memoryRuleSource is used when no source is configured; rules added
+// through the API last until the process exits.
+type memoryRuleSource struct {
+	mu    sync.Mutex
+	rules []model.BlockRule
+}
+
+func (m *memoryRuleSource) Load(ctx context.Context) ([]model.BlockRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]model.BlockRule{}, m.rules...), nil
+}
+
+func (m *memoryRuleSource) Add(ctx context.Context, rule *model.BlockRule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rules = append(m.rules, *rule)
+	return nil
+}
+
+func (m *memoryRuleSource) Remove(ctx context.Context, ruleID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rule := range m.rules {
+		if rule.RuleID == ruleID {
+			m.rules = append(m.rules[:i], m.rules[i+1:]...)
+			return nil
+		}
+	}
+
+	return errRuleNotFound
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (u *userServ) ListBlockRules(c context.Context) ([]model.BlockRule, error) {
+	return u.blocklist.Rules(), nil
+}
+
+func (u *userServ) AddBlockRule(c context.Context, req *model.CreateBlockRuleReq) (*model.BlockRule, error) {
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	rule := &model.BlockRule{
+		RuleID:    primitive.NewObjectID(),
+		Type:      req.Type,
+		Pattern:   strings.TrimSpace(req.Pattern),
+		Action:    req.Action,
+		Reason:    req.Reason,
+		CreatedAt: time.Now(),
+	}
+
+	if rule.Action == "" {
+		rule.Action = "block"
+	}
+
+	if _, err := compileRule(*rule); err != nil {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if err := u.blocklist.Add(ctx, rule); err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return rule, nil
+}
+
+func (u *userServ) RemoveBlockRule(c context.Context, ruleID string) error {
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	id, err := primitive.ObjectIDFromHex(ruleID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "invalid rule id"}
+	}
+
+	err = u.blocklist.Remove(ctx, id)
+	if err == errRuleNotFound {
+		return &utils.AppError{Code: http.StatusNotFound, Message: "rule not found"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (u *userServ) CreateURLs(c context.Context, userID string, urlReqs []model.CreateUrlReq, partial bool) (*model.BulkUrlRes, error) {
+	ctx, cancel := context.WithTimeout(c, 60*time.Second)
+	defer cancel()
+
+	if len(urlReqs) == 0 {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "no urls given"}
+	}
+
+	if len(urlReqs) > model.MaxBulkUrls {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("at most %d urls per request", model.MaxBulkUrls)}
+	}
+
+	uID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	res := &model.BulkUrlRes{Results: make([]model.BulkUrlResult, len(urlReqs))}
+	taken := make(map[string]bool)
+	rows := make([]bulkRow, 0, len(urlReqs))
+
+	for i := range urlReqs {
+		result := &res.Results[i]
+		result.Row = i + 1
+
+		newUrl, err := u.newUrl(ctx, uID, &urlReqs[i], taken)
+		if err != nil {
+			appErr, ok := err.(*utils.AppError)
+			if !ok || appErr.Code >= http.StatusInternalServerError {
+				return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+			}
+
+			result.Error = appErr.Message
+			for _, field := range appErr.Fields {
+				result.Error += fmt.Sprintf("; %s %s", field.Field, field.Message)
+			}
+			res.Failed++
+			continue
+		}
+
+		taken[takenKey(newUrl.Domain, newUrl.ShortURLKey)] = true
+		rows = append(rows, bulkRow{url: newUrl, result: result, generated: urlReqs[i].ShortURLKey == ""})
+		result.UrlID = newUrl.UrlID.Hex()
+		result.ShortURLKey = newUrl.ShortURLKey
+	}
+
+	for attempt := 1; len(rows) > 0; attempt++ {
+		if res.Failed > 0 && !partial {
+			clearBulkResults(res)
+			return res, nil
+		}
+
+		valid := make([]*model.Url, len(rows))
+		for i, row := range rows {
+			valid[i] = row.url
+		}
+
+		err := u.repository.InsertUrls(ctx, valid)
+		if err == nil {
+			for _, url := range valid {
+				u.invalidateUrl(ctx, url)
+			}
+			break
+		}
+
+		if !errors.Is(err, model.ErrDuplicate) {
+			return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+
+		if attempt >= maxKeyAttempts {
+			return nil, &utils.AppError{Code: http.StatusServiceUnavailable, Message: "could not generate a unique key, try again"}
+		}
+
+		rows, err = u.resolveBulkClashes(ctx, rows, res, taken)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res.Failed > 0 && !partial {
+		clearBulkResults(res)
+		return res, nil
+	}
+
+	res.Created = len(rows)
+	return res, nil
+}
+
+type bulkRow struct {
+	url       *model.Url
+	result    *model.BulkUrlResult
+	generated bool
+}
+
+// resolveBulkClashes handles keys claimed by another request after the rows
+// were checked. InsertUrls stored nothing, so every row is checked again:
+// generated keys are replaced, chosen ones fail their row.
+func (u *userServ) resolveBulkClashes(ctx context.Context, rows []bulkRow, res *model.BulkUrlRes, taken map[string]bool) ([]bulkRow, error) {
+	kept := rows[:0]
+
+	for _, row := range rows {
+		count, err := u.repository.CheckUniqueUrlKey(ctx, row.url.Domain, row.url.ShortURLKey)
+		if err != nil {
+			return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+
+		if count == 0 {
+			kept = append(kept, row)
+			continue
+		}
+
+		if !row.generated {
+			row.result.UrlID = ""
+			row.result.ShortURLKey = ""
+			row.result.Error = "endpoint already used"
+			res.Failed++
+			continue
+		}
+
+		u.keyGen.Collision()
+		key, err := u.generateUniqueKey(ctx, row.url.Domain, taken)
+		if err != nil {
+			return nil, err
+		}
+
+		taken[takenKey(row.url.Domain, key)] = true
+		row.url.ShortURLKey = key
+		row.result.ShortURLKey = key
+		kept = append(kept, row)
+	}
+
+	return kept, nil
+}
+
+// clearBulkResults is for when nothing was stored, so the response doesn't
+// report IDs that don't exist.
+func clearBulkResults(res *model.BulkUrlRes) {
+	for i := range res.Results {
+		res.Results[i].UrlID = ""
+		res.Results[i].ShortURLKey = ""
+	}
+}
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"github.com/mssola/useragent"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ClickPipelineConfig struct {
+	QueueSize      int
+	Workers        int
+	BatchSize      int
+	FlushInterval  time.Duration
+	EnqueueTimeout time.Duration
+}
+
+const (
+	geoLookupTimeout     = 2 * time.Second
+	geoLookupConcurrency = 8
+	geoCacheSize         = 10000
+	geoCacheTTL          = time.Hour
+)
+
+var defaultClickPipelineConfig = ClickPipelineConfig{
+	QueueSize:      4096,
+	Workers:        4,
+	BatchSize:      100,
+	FlushInterval:  time.Second,
+	EnqueueTimeout: 5 * time.Millisecond,
+}
+
+// ClickPipeline takes click events off the redirect path. Events are queued
+// in memory and drained by a pool of workers that enrich them and persist
+// them in batches.
+type ClickPipeline struct {
+	repository model.UserRepositoryInterface
+	geo        GeoResolver
+	locations  *geoCache
+	cfg        ClickPipelineConfig
+
+	// mu guards closing queue against concurrent sends.
+	mu      sync.RWMutex
+	closed  bool
+	queue   chan *model.ClickEvent
+	wg      sync.WaitGroup
+	dropped uint64
+}
+
+func NewClickPipeline(repository model.UserRepositoryInterface, geo GeoResolver, cfg ClickPipelineConfig) *ClickPipeline {
+	if geo == nil {
+		geo = noopGeoResolver{}
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultClickPipelineConfig.QueueSize
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultClickPipelineConfig.Workers
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultClickPipelineConfig.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultClickPipelineConfig.FlushInterval
+	}
+	if cfg.EnqueueTimeout < 0 {
+		cfg.EnqueueTimeout = 0
+	}
+
+	p := &ClickPipeline{
+		repository: repository,
+		geo:        geo,
+		locations:  newGeoCache(geoCacheSize, geoCacheTTL),
+		cfg:        cfg,
+		queue:      make(chan *model.ClickEvent, cfg.QueueSize),
+	}
+
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// NewClickPipelineFromEnv reads CLICK_QUEUE_SIZE, CLICK_WORKERS,
+// CLICK_BATCH_SIZE, CLICK_FLUSH_INTERVAL and CLICK_ENQUEUE_TIMEOUT.
+func NewClickPipelineFromEnv(repository model.UserRepositoryInterface, geo GeoResolver) *ClickPipeline {
+	cfg := defaultClickPipelineConfig
+
+	if n, err := strconv.Atoi(os.Getenv("CLICK_QUEUE_SIZE")); err == nil {
+		cfg.QueueSize = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("CLICK_WORKERS")); err == nil {
+		cfg.Workers = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("CLICK_BATCH_SIZE")); err == nil {
+		cfg.BatchSize = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("CLICK_FLUSH_INTERVAL")); err == nil {
+		cfg.FlushInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("CLICK_ENQUEUE_TIMEOUT")); err == nil {
+		cfg.EnqueueTimeout = d
+	}
+
+	return NewClickPipeline(repository, geo, cfg)
+}
+
+// Enqueue hands an event to the workers. When the queue is full it waits at
+// most EnqueueTimeout and then drops the event, so a slow database can
+// never hold up a redirect.
+func (p *ClickPipeline) Enqueue(event *model.ClickEvent) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		atomic.AddUint64(&p.dropped, 1)
+		return false
+	}
+
+	select {
+	case p.queue <- event:
+		return true
+	default:
+	}
+
+	if p.cfg.EnqueueTimeout > 0 {
+		timer := time.NewTimer(p.cfg.EnqueueTimeout)
+		defer timer.Stop()
+
+		select {
+		case p.queue <- event:
+			return true
+		case <-timer.C:
+		}
+	}
+
+	if n := atomic.AddUint64(&p.dropped, 1); n%1000 == 1 {
+		log.Printf("click queue full, %d events dropped so far", n)
+	}
+	return false
+}
+
+// Dropped reports how many events were discarded because of back-pressure.
+func (p *ClickPipeline) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Shutdown stops accepting events and waits until the workers have flushed
+// everything already queued, or until ctx is done.
+func (p *ClickPipeline) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *ClickPipeline) work() {
+	defer p.wg.Done()
+
+	batch := make([]*model.ClickEvent, 0, p.cfg.BatchSize)
+	ticker := time.NewTicker(p.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		p.locate(batch)
+		p.persist(batch)
+		batch = make([]*model.ClickEvent, 0, p.cfg.BatchSize)
+	}
+
+	for {
+		select {
+		case event, ok := <-p.queue:
+			if !ok {
+				flush()
+				return
+			}
+
+			p.enrich(event)
+			batch = append(batch, event)
+			if len(batch) >= p.cfg.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (p *ClickPipeline) enrich(event *model.ClickEvent) {
+	event.ID = primitive.NewObjectID()
+	event.IPHash = hashIP(event.IP)
+
+	ua := useragent.New(event.UserAgent)
+
+	event.OS = "Android"
+	if name := ua.OSInfo().Name; name != "" {
+		event.OS = name
+	}
+
+	event.Browser = "Unknown"
+	if name, _ := ua.Browser(); name != "" {
+		event.Browser = name
+	}
+
+	event.DeviceType = deviceType(ua, event.UserAgent)
+	event.City = "Unknown"
+}
+
+// locate fills in where the clicks in batch came from. Every address is
+// looked up at most once per batch, all under one timeout, and answers are
+// cached by IP hash, so a slow resolver can't hold up each click in turn.
+func (p *ClickPipeline) locate(batch []*model.ClickEvent) {
+	pending := make(map[string][]*model.ClickEvent)
+	for _, event := range batch {
+		if location, ok := p.locations.get(event.IPHash); ok {
+			setLocation(event, location)
+			continue
+		}
+		pending[event.IPHash] = append(pending[event.IPHash], event)
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), geoLookupTimeout)
+	defer cancel()
+
+	var failed int64
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, geoLookupConcurrency)
+	for ipHash, events := range pending {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(ipHash string, events []*model.ClickEvent) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			location, err := p.geo.Resolve(ctx, events[0].IP)
+			if errors.Is(err, errGeoNotFound) {
+				location, err = &GeoLocation{}, nil
+			}
+			if err != nil {
+				atomic.AddInt64(&failed, 1)
+				return
+			}
+
+			p.locations.set(ipHash, location)
+			for _, event := range events {
+				setLocation(event, location)
+			}
+		}(ipHash, events)
+	}
+	wg.Wait()
+
+	// The error would name the address, which is deliberately not kept.
+	if failed > 0 {
+		log.Printf("geolocation lookup failed for %d of %d addresses", failed, len(pending))
+	}
+}
+
+func setLocation(event *model.ClickEvent, location *GeoLocation) {
+	event.Country = location.Country
+	if location.City != "" {
+		event.City = location.City
+	}
+}
+
+// geoCache remembers locations by IP hash. It only needs to spare the
+// resolver repeat visitors, so when it fills up it simply starts over.
+type geoCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]geoCacheEntry
+}
+
+type geoCacheEntry struct {
+	location *GeoLocation
+	expires  time.Time
+}
+
+func newGeoCache(size int, ttl time.Duration) *geoCache {
+	return &geoCache{size: size, ttl: ttl, entries: make(map[string]geoCacheEntry)}
+}
+
+func (c *geoCache) get(ipHash string) (*GeoLocation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[ipHash]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.location, true
+}
+
+func (c *geoCache) set(ipHash string, location *GeoLocation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= c.size {
+		c.entries = make(map[string]geoCacheEntry)
+	}
+	c.entries[ipHash] = geoCacheEntry{location: location, expires: time.Now().Add(c.ttl)}
+}
+
+func deviceType(ua *useragent.UserAgent, raw string) string {
+	switch {
+	case ua.Bot():
+		return "bot"
+	case strings.Contains(raw, "iPad") || strings.Contains(raw, "Tablet"):
+		return "tablet"
+	case ua.Mobile():
+		return "mobile"
+	}
+	return "desktop"
+}
+
+// hashIP pseudonymises a client address with IP_HASH_SALT so clicks from
+// the same visitor can be grouped without storing the address itself.
+func hashIP(ip string) string {
+	sum := sha256.Sum256([]byte(os.Getenv("IP_HASH_SALT") + ip))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *ClickPipeline) persist(batch []*model.ClickEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := p.repository.RecordClicks(ctx, batch); err != nil {
+		log.Printf("failed to record %d clicks: %v", len(batch), err)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/idna"
+)
+
+// TXTResolver looks up DNS TXT records. *net.Resolver satisfies it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// StaticTXTResolver answers from a fixed table of record name to values,
+// for tests and offline setups.
+type StaticTXTResolver map[string][]string
+
+func (s StaticTXTResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := s[strings.TrimSuffix(name, ".")]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+const domainVerifyPrefix = "_shortener-verify."
+
+func domainVerifyRecord(token string) string {
+	return "shortener-verify=" + token
+}
+
+// normalizeHost lowercases host, drops any port and trailing dot and
+// converts it to punycode.
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	if host == "" {
+		return "", fmt.Errorf("host is required")
+	}
+
+	return idna.Lookup.ToASCII(host)
+}
+
+func (u *userServ) AddDomain(c context.Context, userID string, req *model.CreateDomainReq) (*model.Domain, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	host, err := normalizeHost(req.Host)
+	if err != nil || !strings.Contains(host, ".") || net.ParseIP(host) != nil {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid host"}
+	}
+
+	if u.urls.ownHosts[host] {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "host is already served by this shortener"}
+	}
+
+	// Unverified claims don't block anyone; the host goes to whoever proves
+	// they control it first.
+	_, err = u.repository.GetDomainByHost(ctx, host)
+	if err == nil {
+		return nil, &utils.AppError{Code: http.StatusConflict, Message: "domain already registered"}
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	domain := &model.Domain{
+		DomainID:          primitive.NewObjectID(),
+		UserID:            uid,
+		Host:              host,
+		VerificationToken: hex.EncodeToString(token),
+		CreatedAt:         time.Now(),
+	}
+
+	err = u.repository.InsertDomain(ctx, domain)
+	if errors.Is(err, model.ErrDuplicate) {
+		return nil, &utils.AppError{Code: http.StatusConflict, Message: "domain already registered"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return domain, nil
+}
+
+func (u *userServ) ListDomains(c context.Context, userID string) ([]model.Domain, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	res, err := u.repository.GetDomainsByUser(ctx, uid)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return res, nil
+}
+
+// VerifyDomain checks that _shortener-verify.<host> has a TXT record
+// "shortener-verify=<token>".
+func (u *userServ) VerifyDomain(c context.Context, userID string, domainID string) (*model.Domain, error) {
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	id, err := primitive.ObjectIDFromHex(domainID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid domain id"}
+	}
+
+	domain, err := u.repository.GetDomainByID(ctx, id)
+	if err == mongo.ErrNoDocuments || (err == nil && domain.UserID != uid) {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "domain not found"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if domain.Verified {
+		return domain, nil
+	}
+
+	records, err := u.resolver.LookupTXT(ctx, domainVerifyPrefix+domain.Host)
+	if err != nil {
+		log.Printf("txt lookup for %s failed: %v", domain.Host, err)
+	}
+
+	want := domainVerifyRecord(domain.VerificationToken)
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == want {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: fmt.Sprintf("TXT record %q not found on %s", want, domainVerifyPrefix+domain.Host)}
+	}
+
+	now := time.Now()
+	err = u.repository.VerifyDomain(ctx, domain.DomainID, now)
+	u.cache.InvalidateHost(ctx, domain.Host)
+	if errors.Is(err, model.ErrDuplicate) {
+		return nil, &utils.AppError{Code: http.StatusConflict, Message: "domain already registered"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	domain.Verified = true
+	domain.VerifiedAt = &now
+	return domain, nil
+}
+
+// ownedDomain returns the verified domain host userID may create links on.
+func (u *userServ) ownedDomain(ctx context.Context, uID primitive.ObjectID, host string) (string, error) {
+	host, err := normalizeHost(host)
+	if err != nil {
+		return "", &utils.AppError{Code: http.StatusBadRequest, Message: "invalid domain"}
+	}
+
+	domain, err := u.repository.GetDomainByHost(ctx, host)
+	if err == nil && domain.UserID == uID {
+		return domain.Host, nil
+	}
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if err == mongo.ErrNoDocuments {
+		claims, err := u.repository.GetDomainsByUser(ctx, uID)
+		if err != nil {
+			return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+		for _, claim := range claims {
+			if claim.Host == host {
+				return "", &utils.AppError{Code: http.StatusBadRequest, Message: "domain is not verified yet"}
+			}
+		}
+	}
+
+	return "", &utils.AppError{Code: http.StatusBadRequest, Message: "domain is not registered to you"}
+}
+
+// linkDomain maps the Host a redirect arrived on to the domain its links
+// are stored under. Anything that is not a verified custom domain is
+// treated as the default domain. Every redirect needs this, so answers are
+// cached like links are.
+func (u *userServ) linkDomain(ctx context.Context, host string) (string, error) {
+	host, err := normalizeHost(host)
+	if err != nil || u.urls.ownHosts[host] {
+		return "", nil
+	}
+
+	cached, ok, generation := u.cache.GetHost(host)
+	if ok {
+		return cached, nil
+	}
+
+	domain, err := u.repository.GetDomainByHost(ctx, host)
+	if err == mongo.ErrNoDocuments {
+		u.cache.SetHost(host, "", generation)
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !domain.Verified {
+		u.cache.SetHost(host, "", generation)
+		return "", nil
+	}
+
+	u.cache.SetHost(host, domain.Host, generation)
+	return domain.Host, nil
+}
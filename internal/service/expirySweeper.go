@@ -0,0 +1,51 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+)
+
+const defaultSweepInterval = time.Minute
+
+// StartExpirySweeper periodically marks links that have passed their expiry
+// date or click limit as expired, until ctx is cancelled. The interval is
+// read from URL_SWEEP_INTERVAL.
+func StartExpirySweeper(ctx context.Context, repository model.UserRepositoryInterface) {
+	interval, err := time.ParseDuration(os.Getenv("URL_SWEEP_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultSweepInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sweepExpiredURLs(ctx, repository)
+			}
+		}
+	}()
+}
+
+func sweepExpiredURLs(c context.Context, repository model.UserRepositoryInterface) {
+	ctx, cancel := context.WithTimeout(c, 30*time.Second)
+	defer cancel()
+
+	n, err := repository.ExpireURLs(ctx, time.Now())
+	if err != nil {
+		log.Printf("expiry sweep failed: %v", err)
+		return
+	}
+
+	if n > 0 {
+		log.Printf("expiry sweep marked %d urls as expired", n)
+	}
+}
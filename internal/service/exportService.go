@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var exportColumns = []string{
+	"url_id", "label", "long_url", "short_url_key", "domain", "redirect_type", "no_of_clicks",
+	"disabled", "expired", "protected", "expires_at", "max_clicks", "tags", "created_at",
+}
+
+// ExportURLs streams every link the user owns to w as CSV or JSON Lines,
+// with the device and location counters flattened into device_<name> and
+// location_<name> columns.
+func (u *userServ) ExportURLs(c context.Context, userID string, format string, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(c, 5*time.Minute)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	switch format {
+	case "csv":
+		err = u.exportCSV(ctx, uid, w)
+	case "jsonl":
+		err = u.exportJSONL(ctx, uid, w)
+	default:
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "format must be csv or jsonl"}
+	}
+
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+func (u *userServ) exportCSV(ctx context.Context, uid primitive.ObjectID, w io.Writer) error {
+	// CSV needs every column up front, so a first pass collects the device
+	// and location names. Only the names are kept in memory.
+	devices, locations := make(map[string]bool), make(map[string]bool)
+	err := u.repository.EachURL(ctx, uid, func(url *model.Url) error {
+		for name := range url.Device {
+			devices[name] = true
+		}
+		for name := range url.Location {
+			locations[name] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	deviceNames, locationNames := sortedKeys(devices), sortedKeys(locations)
+
+	header := append([]string{}, exportColumns...)
+	for _, name := range deviceNames {
+		header = append(header, "device_"+name)
+	}
+	for _, name := range locationNames {
+		header = append(header, "location_"+name)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	err = u.repository.EachURL(ctx, uid, func(url *model.Url) error {
+		row := exportRow(url)
+		for _, name := range deviceNames {
+			row = append(row, strconv.Itoa(url.Device[name]))
+		}
+		for _, name := range locationNames {
+			row = append(row, strconv.Itoa(url.Location[name]))
+		}
+		return cw.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (u *userServ) exportJSONL(ctx context.Context, uid primitive.ObjectID, w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	return u.repository.EachURL(ctx, uid, func(url *model.Url) error {
+		row := exportRow(url)
+		record := make(map[string]interface{}, len(row)+len(url.Device)+len(url.Location))
+		for i, column := range exportColumns {
+			record[column] = row[i]
+		}
+		record["redirect_type"] = url.RedirectStatus()
+		record["no_of_clicks"] = url.NoOfClicks
+		record["max_clicks"] = url.MaxClicks
+		record["disabled"] = url.Disabled
+		record["expired"] = url.Expired
+		record["protected"] = url.Protected
+		record["tags"] = url.Tags
+
+		for name, n := range url.Device {
+			record["device_"+name] = n
+		}
+		for name, n := range url.Location {
+			record["location_"+name] = n
+		}
+
+		return enc.Encode(record)
+	})
+}
+
+func exportRow(url *model.Url) []string {
+	var expiresAt string
+	if url.ExpiresAt != nil {
+		expiresAt = url.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+
+	return []string{
+		url.UrlID.Hex(),
+		url.Label,
+		url.LongURL,
+		url.ShortURLKey,
+		url.Domain,
+		strconv.Itoa(url.RedirectStatus()),
+		strconv.Itoa(url.NoOfClicks),
+		strconv.FormatBool(url.Disabled),
+		strconv.FormatBool(url.Expired),
+		strconv.FormatBool(url.Protected),
+		expiresAt,
+		strconv.Itoa(url.MaxClicks),
+		strings.Join(url.Tags, "|"),
+		url.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type GeoLocation struct {
+	Country string `json:"country"`
+	City    string `json:"city"`
+}
+
+// GeoResolver maps a client IP to a location. Implementations should be
+// safe for concurrent use.
+type GeoResolver interface {
+	Resolve(ctx context.Context, ip string) (*GeoLocation, error)
+}
+
+var errGeoNotFound = errors.New("geolocation: address not found")
+
+// NewGeoResolver builds the resolver named by provider: "mmdb" and "csv"
+// read the local file at source, "http" queries ip-api.com (or source, if
+// set) and "none", the default, disables lookups. Deployments may have no
+// outbound internet, so the third-party API is only used when asked for.
+func NewGeoResolver(provider string, source string) (GeoResolver, error) {
+	switch provider {
+	case "mmdb":
+		return newMMDBGeoResolver(source)
+	case "csv":
+		return newCSVGeoResolver(source)
+	case "http":
+		return newHTTPGeoResolver(source), nil
+	case "none", "":
+		return noopGeoResolver{}, nil
+	}
+
+	return nil, fmt.Errorf("geolocation: unknown provider %q", provider)
+}
+
+// NewGeoResolverFromEnv reads GEO_PROVIDER and GEO_SOURCE.
+func NewGeoResolverFromEnv() (GeoResolver, error) {
+	return NewGeoResolver(os.Getenv("GEO_PROVIDER"), os.Getenv("GEO_SOURCE"))
+}
+
+type noopGeoResolver struct{}
+
+func (noopGeoResolver) Resolve(ctx context.Context, ip string) (*GeoLocation, error) {
+	return &GeoLocation{}, nil
+}
+
+type mmdbGeoResolver struct {
+	reader *maxminddb.Reader
+}
+
+type mmdbRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+}
+
+func newMMDBGeoResolver(path string) (*mmdbGeoResolver, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mmdbGeoResolver{reader}, nil
+}
+
+func (m *mmdbGeoResolver) Resolve(ctx context.Context, ip string) (*GeoLocation, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("geolocation: invalid ip %q", ip)
+	}
+
+	var record mmdbRecord
+	if err := m.reader.Lookup(addr, &record); err != nil {
+		return nil, err
+	}
+
+	country := record.Country.Names["en"]
+	if country == "" {
+		country = record.Country.ISOCode
+	}
+
+	return &GeoLocation{Country: country, City: record.City.Names["en"]}, nil
+}
+
+type ipRange struct {
+	start    net.IP
+	end      net.IP
+	location GeoLocation
+}
+
+// csvGeoResolver answers from a table of start_ip,end_ip,country,city rows.
+type csvGeoResolver struct {
+	ranges []ipRange
+}
+
+func newCSVGeoResolver(path string) (*csvGeoResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+
+	var ranges []ipRange
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(row) < 3 {
+			return nil, fmt.Errorf("geolocation: %s:%d: expected start_ip,end_ip,country[,city]", path, line)
+		}
+
+		start, end := net.ParseIP(strings.TrimSpace(row[0])), net.ParseIP(strings.TrimSpace(row[1]))
+		if start == nil || end == nil {
+			// Tolerate a header row.
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("geolocation: %s:%d: invalid ip range", path, line)
+		}
+
+		rng := ipRange{
+			start:    start.To16(),
+			end:      end.To16(),
+			location: GeoLocation{Country: strings.TrimSpace(row[2])},
+		}
+		if len(row) > 3 {
+			rng.location.City = strings.TrimSpace(row[3])
+		}
+
+		ranges = append(ranges, rng)
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].start, ranges[j].start) < 0
+	})
+
+	return &csvGeoResolver{ranges}, nil
+}
+
+func (c *csvGeoResolver) Resolve(ctx context.Context, ip string) (*GeoLocation, error) {
+	addr := net.ParseIP(ip).To16()
+	if addr == nil {
+		return nil, fmt.Errorf("geolocation: invalid ip %q", ip)
+	}
+
+	// Find the last range starting at or before addr.
+	i := sort.Search(len(c.ranges), func(i int) bool {
+		return bytes.Compare(c.ranges[i].start, addr) > 0
+	}) - 1
+
+	if i < 0 || bytes.Compare(addr, c.ranges[i].end) > 0 {
+		return nil, errGeoNotFound
+	}
+
+	location := c.ranges[i].location
+	return &location, nil
+}
+
+const defaultGeoEndpoint = "http://ip-api.com/json/"
+
+type httpGeoResolver struct {
+	endpoint string
+	client   *http.Client
+}
+
+func newHTTPGeoResolver(endpoint string) *httpGeoResolver {
+	if endpoint == "" {
+		endpoint = defaultGeoEndpoint
+	}
+
+	return &httpGeoResolver{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 2 * time.Second},
+	}
+}
+
+type ipAPIResponse struct {
+	Status  string `json:"status"`
+	Country string `json:"country"`
+	City    string `json:"city"`
+}
+
+func (h *httpGeoResolver) Resolve(ctx context.Context, ip string) (*GeoLocation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.endpoint+ip, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("geolocation: lookup returned %s", res.Status)
+	}
+
+	var data ipAPIResponse
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	if data.Status == "fail" {
+		return nil, errGeoNotFound
+	}
+
+	return &GeoLocation{Country: data.Country, City: data.City}, nil
+}
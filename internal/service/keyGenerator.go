@@ -0,0 +1,89 @@
+package service
+
+import (
+	"crypto/rand"
+	"math/big"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultKeyAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	defaultKeyLength   = 6
+	maxKeyLength       = 32
+
+	// growAfterCollisions is how many collisions in a row at the current
+	// length we tolerate before assuming the keyspace is filling up.
+	growAfterCollisions = 3
+)
+
+type keyGenerator struct {
+	alphabet []rune
+
+	mu         sync.Mutex
+	length     int
+	collisions int
+}
+
+func newKeyGenerator(alphabet string, length int) *keyGenerator {
+	if alphabet == "" {
+		alphabet = defaultKeyAlphabet
+	}
+	if length <= 0 {
+		length = defaultKeyLength
+	}
+	if length > maxKeyLength {
+		length = maxKeyLength
+	}
+
+	return &keyGenerator{
+		alphabet: []rune(alphabet),
+		length:   length,
+	}
+}
+
+// newKeyGeneratorFromEnv reads KEY_ALPHABET and KEY_LENGTH, falling back to
+// base62 keys of six characters.
+func newKeyGeneratorFromEnv() *keyGenerator {
+	length, _ := strconv.Atoi(os.Getenv("KEY_LENGTH"))
+	return newKeyGenerator(os.Getenv("KEY_ALPHABET"), length)
+}
+
+func (k *keyGenerator) Generate() (string, error) {
+	k.mu.Lock()
+	length := k.length
+	k.mu.Unlock()
+
+	max := big.NewInt(int64(len(k.alphabet)))
+	key := make([]rune, length)
+	for i := range key {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		key[i] = k.alphabet[n.Int64()]
+	}
+
+	return string(key), nil
+}
+
+// Collision records that a generated key was already taken. Repeated
+// collisions grow the key length by one so the keyspace keeps up with usage.
+func (k *keyGenerator) Collision() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.collisions++
+	if k.collisions >= growAfterCollisions && k.length < maxKeyLength {
+		k.length++
+		k.collisions = 0
+	}
+}
+
+// Accepted resets the collision streak after a key was stored.
+func (k *keyGenerator) Accepted() {
+	k.mu.Lock()
+	k.collisions = 0
+	k.mu.Unlock()
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends plain-text email.
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// NewMailerFromEnv picks the mailer from MAILER: "smtp" sends through
+// SMTP_HOST:SMTP_PORT (authenticating with SMTP_USERNAME and SMTP_PASSWORD
+// when set), "file" appends messages to MAIL_FILE and "log" (the default)
+// writes them to the log with their tokens redacted. MAIL_FROM is the
+// sender address.
+func NewMailerFromEnv() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("mailer: SMTP_HOST is required")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &smtpMailer{
+			addr:     net.JoinHostPort(host, port),
+			host:     host,
+			username: os.Getenv("SMTP_USERNAME"),
+			password: os.Getenv("SMTP_PASSWORD"),
+			from:     from,
+		}, nil
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("mailer: MAIL_FILE is required")
+		}
+		return &fileMailer{path: path, from: from}, nil
+	case "log", "":
+		return logMailer{}, nil
+	}
+
+	return nil, fmt.Errorf("mailer: unknown mailer %q", os.Getenv("MAILER"))
+}
+
+// headerValue drops line breaks so a value can't add headers of its own.
+var headerValue = strings.NewReplacer("\r", "", "\n", "").Replace
+
+func formatMessage(from string, msg *Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+type smtpMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func (s *smtpMailer) Send(ctx context.Context, msg *Message) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	// net/smtp takes no context, so honour cancellation by not waiting on it.
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(s.addr, auth, s.from, []string{msg.To}, formatMessage(s.from, msg))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// fileMailer appends every message to a file, for local development and
+// tests that need to read what was sent.
+type fileMailer struct {
+	path string
+	from string
+
+	mu sync.Mutex
+}
+
+func (f *fileMailer) Send(ctx context.Context, msg *Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(append(formatMessage(f.from, msg), "\r\n\r\n"...)); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// logMailer only records that a message was sent. Tokens in links are
+// redacted, since anyone who can read the log could otherwise use them.
+type logMailer struct{}
+
+var mailToken = regexp.MustCompile(`token=[^\s&]+`)
+
+func (logMailer) Send(ctx context.Context, msg *Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, mailToken.ReplaceAllString(msg.Body, "token=REDACTED"))
+	return nil
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// accessTokenHours is how long an access token is valid.
+	accessTokenHours = 10
+
+	// sessionTTL is how long a session lives without being refreshed.
+	sessionTTL = 72 * time.Hour
+
+	// activeSessionTTL is how long a session found active is trusted
+	// without asking the database again. Revocations made through this
+	// instance apply at once; others within this long.
+	activeSessionTTL = 30 * time.Second
+)
+
+// startSession records a new session for user and returns its first token
+// pair.
+func (u *userServ) startSession(ctx context.Context, user *model.User, meta *model.SessionMeta) (*model.TokenPair, error) {
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	now := time.Now()
+	session := &model.Session{
+		SessionID:      primitive.NewObjectID(),
+		UserID:         user.UserID,
+		TokenHash:      utils.HashToken(refreshToken),
+		PreviousHashes: []string{},
+		CreatedAt:      now,
+		LastUsedAt:     now,
+		ExpiresAt:      now.Add(sessionTTL),
+	}
+	if meta != nil {
+		session.UserAgent, session.IP = meta.UserAgent, meta.IP
+	}
+
+	if err := u.repository.InsertSession(ctx, session); err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	accessToken, err := utils.GenerateAccessToken(user, session.SessionID.Hex(), os.Getenv("ACCESS_TOKEN_SECRET"), accessTokenHours)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return &model.TokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// RefreshAccessToken exchanges a refresh token for a new token pair. Each
+// refresh token works once: presenting one that was already rotated means
+// it was copied, so the whole session is revoked.
+func (u *userServ) RefreshAccessToken(c context.Context, refreshToken string, meta *model.SessionMeta) (*model.TokenPair, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	if refreshToken == "" {
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "invalid refresh token"}
+	}
+
+	oldHash := utils.HashToken(refreshToken)
+
+	session, err := u.repository.GetSessionByTokenHash(ctx, oldHash)
+	if err == mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "invalid refresh token"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	now := time.Now()
+
+	if session.RevokedAt != nil {
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "session revoked"}
+	}
+
+	if session.TokenHash != oldHash {
+		u.revokeReusedSession(ctx, session, now)
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "refresh token reused, session revoked"}
+	}
+
+	if !now.Before(session.ExpiresAt) {
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "expired refresh token"}
+	}
+
+	user, err := u.repository.GetUserById(ctx, session.UserID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	newToken, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	session.TokenHash = utils.HashToken(newToken)
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(sessionTTL)
+	if meta != nil {
+		session.UserAgent, session.IP = meta.UserAgent, meta.IP
+	}
+
+	// Losing this race means another request rotated the same token first,
+	// which is as suspicious as a replay.
+	err = u.repository.RotateSession(ctx, session, oldHash)
+	if err == mongo.ErrNoDocuments {
+		u.revokeReusedSession(ctx, session, now)
+		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "refresh token reused, session revoked"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	accessToken, err := utils.GenerateAccessToken(user, session.SessionID.Hex(), os.Getenv("ACCESS_TOKEN_SECRET"), accessTokenHours)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return &model.TokenPair{AccessToken: accessToken, RefreshToken: newToken}, nil
+}
+
+func (u *userServ) revokeReusedSession(ctx context.Context, session *model.Session, now time.Time) {
+	log.Printf("refresh token reuse detected for session %s of user %s", session.SessionID.Hex(), session.UserID.Hex())
+
+	err := u.repository.RevokeSession(ctx, session.SessionID, session.UserID, now)
+	u.sessions.forget(session.SessionID.Hex())
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("failed to revoke session %s: %v", session.SessionID.Hex(), err)
+	}
+}
+
+// Logout revokes the session the access token was issued for.
+func (u *userServ) Logout(c context.Context, userID string, sessionID string) error {
+	if sessionID == "" {
+		return nil
+	}
+
+	err := u.RevokeSession(c, userID, sessionID)
+	if appErr, ok := err.(*utils.AppError); ok && appErr.Code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (u *userServ) ListSessions(c context.Context, userID string, currentSessionID string) ([]model.Session, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	res, err := u.repository.GetSessionsByUser(ctx, uid, time.Now())
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	for i := range res {
+		res[i].Current = res[i].SessionID.Hex() == currentSessionID
+	}
+
+	return res, nil
+}
+
+func (u *userServ) RevokeSession(c context.Context, userID string, sessionID string) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	id, err := primitive.ObjectIDFromHex(sessionID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "invalid session id"}
+	}
+
+	err = u.repository.RevokeSession(ctx, id, uid, time.Now())
+	u.sessions.forget(sessionID)
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusNotFound, Message: "session not found"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+// CheckSession fails once the session an access token was issued for has
+// been revoked or has expired.
+func (u *userServ) CheckSession(c context.Context, userID string, sessionID string) error {
+	now := time.Now()
+	if u.sessions.active(sessionID, now) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusUnauthorized, Message: "invalid access token"}
+	}
+
+	sessions, err := u.repository.GetSessionsByUser(ctx, uid, now)
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	for _, session := range sessions {
+		if session.SessionID.Hex() == sessionID {
+			until := now.Add(activeSessionTTL)
+			if session.ExpiresAt.Before(until) {
+				until = session.ExpiresAt
+			}
+			u.sessions.add(userID, sessionID, until)
+			return nil
+		}
+	}
+
+	return &utils.AppError{Code: http.StatusUnauthorized, Message: "session revoked"}
+}
+
+// activeSessions remembers sessions recently found active, so checking
+// every request's token doesn't cost a query each.
+type activeSessions struct {
+	mu        sync.Mutex
+	sessions  map[string]activeSession
+	lastSweep time.Time
+}
+
+type activeSession struct {
+	userID string
+	until  time.Time
+}
+
+func newActiveSessions() *activeSessions {
+	return &activeSessions{sessions: make(map[string]activeSession)}
+}
+
+func (a *activeSessions) active(sessionID string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.sessions[sessionID]
+	return ok && now.Before(s.until)
+}
+
+func (a *activeSessions) add(userID string, sessionID string, until time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(a.lastSweep) >= activeSessionTTL {
+		a.lastSweep = now
+		for id, s := range a.sessions {
+			if !now.Before(s.until) {
+				delete(a.sessions, id)
+			}
+		}
+	}
+
+	a.sessions[sessionID] = activeSession{userID: userID, until: until}
+}
+
+func (a *activeSessions) forget(sessionID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.sessions, sessionID)
+}
+
+func (a *activeSessions) forgetUser(userID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for id, s := range a.sessions {
+		if s.userID == userID {
+			delete(a.sessions, id)
+		}
+	}
+}
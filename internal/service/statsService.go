@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+)
+
+const (
+	defaultStatsWindow = 7 * 24 * time.Hour
+	maxStatsBuckets    = 2000
+)
+
+func (u *userServ) GetURLStats(c context.Context, userID string, key string, query *model.UrlStatsQuery) (*model.UrlStats, error) {
+	ctx, cancel := context.WithTimeout(c, 10*time.Second)
+	defer cancel()
+
+	link, err := u.getAuthorizedUrlByKey(ctx, userID, query.Domain, key, model.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := query.Interval
+	if interval == "" {
+		interval = "day"
+	}
+	if interval != "hour" && interval != "day" && interval != "week" {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "interval must be hour, day or week"}
+	}
+
+	to := query.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	from := query.From
+	if from.IsZero() {
+		from = to.Add(-defaultStatsWindow)
+	}
+	from, to = from.UTC(), to.UTC()
+
+	if !from.Before(to) {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "from must be before to"}
+	}
+
+	series := make([]model.StatsBucket, 0)
+	index := make(map[time.Time]int)
+	for start := truncateToInterval(from, interval); start.Before(to); start = nextInterval(start, interval) {
+		if len(series) == maxStatsBuckets {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "window too large for interval"}
+		}
+		index[start] = len(series)
+		series = append(series, model.StatsBucket{Start: start})
+	}
+
+	stats := &model.UrlStats{
+		Key:           link.ShortURLKey,
+		TotalClicks:   link.NoOfClicks,
+		TotalDevice:   link.Device,
+		TotalLocation: link.Location,
+		From:          from,
+		To:            to,
+		Interval:      interval,
+		Countries:     make(map[string]int),
+		Cities:        make(map[string]int),
+		OS:            make(map[string]int),
+		Browsers:      make(map[string]int),
+		Referrers:     make(map[string]int),
+	}
+
+	// Weeks are summed from days, since both start at UTC midnight.
+	bucket := 24 * time.Hour
+	if interval == "hour" {
+		bucket = time.Hour
+	}
+
+	filter := &model.ClickEventFilter{Key: link.ShortURLKey, Domain: link.Domain, From: from, To: to}
+	agg, err := u.repository.AggregateClicks(ctx, filter, bucket)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	for start, clicks := range agg.Buckets {
+		if i, ok := index[truncateToInterval(start, interval)]; ok {
+			series[i].Clicks += clicks
+		}
+	}
+
+	stats.Clicks = agg.Total
+	for value, clicks := range agg.Countries {
+		stats.Countries[orUnknown(value)] += clicks
+	}
+	for value, clicks := range agg.Cities {
+		stats.Cities[orUnknown(value)] += clicks
+	}
+	for value, clicks := range agg.OS {
+		stats.OS[orUnknown(value)] += clicks
+	}
+	for value, clicks := range agg.Browsers {
+		stats.Browsers[orUnknown(value)] += clicks
+	}
+	for value, clicks := range agg.Referrers {
+		stats.Referrers[referrerHost(value)] += clicks
+	}
+
+	stats.Series = series
+	return stats, nil
+}
+
+func truncateToInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return t.Truncate(time.Hour)
+	case "week":
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		// Weeks start on Monday.
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func nextInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return t.Add(time.Hour)
+	case "week":
+		return t.AddDate(0, 0, 7)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "Unknown"
+	}
+	return s
+}
+
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return "direct"
+	}
+	if u, err := url.Parse(referrer); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return referrer
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+)
+
+// CacheBroadcaster carries invalidations between instances, so a link
+// edited through one stops being served stale by the others.
+type CacheBroadcaster interface {
+	Publish(ctx context.Context, key string) error
+	Subscribe(fn func(key string))
+}
+
+// MemoryBroadcaster delivers invalidations within the process, which is
+// all a single instance needs.
+type MemoryBroadcaster struct {
+	mu          sync.RWMutex
+	subscribers []func(key string)
+}
+
+func NewMemoryBroadcaster() *MemoryBroadcaster {
+	return &MemoryBroadcaster{}
+}
+
+func (b *MemoryBroadcaster) Publish(ctx context.Context, key string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, fn := range b.subscribers {
+		fn(key)
+	}
+	return nil
+}
+
+func (b *MemoryBroadcaster) Subscribe(fn func(key string)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, fn)
+}
+
+// urlCacheStats is published at /admin/metrics. It is shared by every
+// cache in the process because expvar names can only be registered once.
+var urlCacheStats = expvar.NewMap("url_cache")
+
+// UrlCache keeps recently resolved links, and the custom domains redirects
+// arrive on, in memory, least recently used first out. Keys and hosts that
+// don't exist are cached too, for a shorter time, so requests for random
+// keys don't all reach the database.
+type UrlCache struct {
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	broadcaster CacheBroadcaster
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	// generation changes on every invalidation, so a lookup that read the
+	// database before an edit can't store what it read after it.
+	generation uint64
+}
+
+type urlCacheEntry struct {
+	key     string
+	url     *model.Url
+	domain  string
+	expires time.Time
+}
+
+// hostCacheKey keeps hosts apart from link keys, which always contain a
+// slash.
+func hostCacheKey(host string) string {
+	return "host:" + host
+}
+
+// NewUrlCache holds up to size links; a size of 0 disables caching.
+func NewUrlCache(size int, ttl time.Duration, negativeTTL time.Duration, broadcaster CacheBroadcaster) *UrlCache {
+	if broadcaster == nil {
+		broadcaster = NewMemoryBroadcaster()
+	}
+
+	c := &UrlCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		broadcaster: broadcaster,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+	}
+	broadcaster.Subscribe(c.evict)
+
+	return c
+}
+
+// NewUrlCacheFromEnv reads URL_CACHE_SIZE (default 10000, 0 disables),
+// URL_CACHE_TTL (default 1m), URL_CACHE_NEGATIVE_TTL (default 10s) and
+// URL_CACHE_BROADCASTER. Only "memory" (the default) is built in; running
+// several instances needs a shared broadcaster passed to NewUrlCache, or a
+// TTL short enough to live with.
+func NewUrlCacheFromEnv() (*UrlCache, error) {
+	size := 10000
+	if n, err := strconv.Atoi(os.Getenv("URL_CACHE_SIZE")); err == nil && n >= 0 {
+		size = n
+	}
+
+	ttl := time.Minute
+	if d, err := time.ParseDuration(os.Getenv("URL_CACHE_TTL")); err == nil && d > 0 {
+		ttl = d
+	}
+
+	negativeTTL := 10 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("URL_CACHE_NEGATIVE_TTL")); err == nil && d > 0 {
+		negativeTTL = d
+	}
+
+	var broadcaster CacheBroadcaster
+	switch os.Getenv("URL_CACHE_BROADCASTER") {
+	case "memory", "":
+		broadcaster = NewMemoryBroadcaster()
+	default:
+		return nil, fmt.Errorf("url cache: unknown broadcaster %q", os.Getenv("URL_CACHE_BROADCASTER"))
+	}
+
+	return NewUrlCache(size, ttl, negativeTTL, broadcaster), nil
+}
+
+// Get returns the cached link for key, or nil if the key is known not to
+// exist. ok is false on a miss; generation is then passed back to Set.
+func (c *UrlCache) Get(key string) (url *model.Url, ok bool, generation uint64) {
+	entry, ok, generation := c.get(key)
+	if !ok {
+		return nil, false, generation
+	}
+	return copyUrl(entry.url), true, generation
+}
+
+// Set stores url, or nil for a missing key, unless the cache was
+// invalidated since the Get that returned generation.
+func (c *UrlCache) Set(key string, url *model.Url, generation uint64) {
+	ttl := c.ttl
+	if url == nil {
+		ttl = c.negativeTTL
+	}
+	c.set(&urlCacheEntry{key: key, url: copyUrl(url), expires: time.Now().Add(ttl)}, generation)
+}
+
+// GetHost returns the verified domain host stands for, or "" if it is not
+// one. ok and generation work as they do for Get.
+func (c *UrlCache) GetHost(host string) (domain string, ok bool, generation uint64) {
+	entry, ok, generation := c.get(hostCacheKey(host))
+	if !ok {
+		return "", false, generation
+	}
+	return entry.domain, true, generation
+}
+
+// SetHost stores the domain host stands for, "" for none.
+func (c *UrlCache) SetHost(host string, domain string, generation uint64) {
+	ttl := c.ttl
+	if domain == "" {
+		ttl = c.negativeTTL
+	}
+	c.set(&urlCacheEntry{key: hostCacheKey(host), domain: domain, expires: time.Now().Add(ttl)}, generation)
+}
+
+// InvalidateHost drops host, as Invalidate does for a link.
+func (c *UrlCache) InvalidateHost(ctx context.Context, host string) {
+	c.Invalidate(ctx, hostCacheKey(host))
+}
+
+func (c *UrlCache) get(key string) (*urlCacheEntry, bool, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, found := c.entries[key]
+	if found && time.Now().Before(el.Value.(*urlCacheEntry).expires) {
+		c.order.MoveToFront(el)
+		urlCacheStats.Add("hits", 1)
+		return el.Value.(*urlCacheEntry), true, c.generation
+	}
+
+	if found {
+		c.remove(el)
+	}
+	urlCacheStats.Add("misses", 1)
+	return nil, false, c.generation
+}
+
+func (c *UrlCache) set(entry *urlCacheEntry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 || generation != c.generation {
+		return
+	}
+
+	if el, found := c.entries[entry.key]; found {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[entry.key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		urlCacheStats.Add("evictions", 1)
+	}
+}
+
+// Invalidate drops key here and, through the broadcaster, everywhere else.
+func (c *UrlCache) Invalidate(ctx context.Context, key string) {
+	urlCacheStats.Add("invalidations", 1)
+	c.evict(key)
+	if err := c.broadcaster.Publish(ctx, key); err != nil {
+		log.Printf("url cache: publishing invalidation of %q failed: %v", key, err)
+	}
+}
+
+func (c *UrlCache) evict(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if el, found := c.entries[key]; found {
+		c.remove(el)
+	}
+}
+
+func (c *UrlCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*urlCacheEntry).key)
+}
+
+// copyUrl keeps callers from changing what other requests are served.
+func copyUrl(url *model.Url) *model.Url {
+	if url == nil {
+		return nil
+	}
+	return url.Clone()
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (u *userServ) UpdateURL(c context.Context, userID string, urlID string, req *model.UpdateUrlReq) (*model.Url, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	url, err := u.getAuthorizedUrlByID(ctx, userID, urlID, model.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	if req.Label != nil {
+		url.Label = *req.Label
+	}
+
+	err = u.normalizeURLs(ctx,
+		urlField{name: "long_url", value: req.LongURL},
+		urlField{name: "fallback_url", value: req.FallbackURL, optional: true},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.LongURL != nil {
+		url.LongURL = *req.LongURL
+	}
+
+	if req.RedirectType != nil {
+		redirectType, err := validateRedirectType(*req.RedirectType)
+		if err != nil {
+			return nil, err
+		}
+		url.RedirectType = redirectType
+	}
+
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "expires_at must be in the future"}
+		}
+		url.ExpiresAt = req.ExpiresAt
+	}
+
+	if req.MaxClicks != nil {
+		if *req.MaxClicks < 0 {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "max_clicks cannot be negative"}
+		}
+		url.MaxClicks = *req.MaxClicks
+	}
+
+	if req.FallbackURL != nil {
+		url.FallbackURL = *req.FallbackURL
+	}
+
+	if req.Password != nil {
+		url.PasswordHash = ""
+		if *req.Password != "" {
+			url.PasswordHash, err = utils.HashPassword(*req.Password)
+			if err != nil {
+				return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+			}
+		}
+		url.Protected = url.PasswordHash != ""
+	}
+
+	// A new expiry date or click limit may bring an expired link back.
+	url.Expired = false
+	url.Expired = url.IsExpired(now)
+	url.UpdatedAt = now
+
+	if err := u.saveUrl(ctx, url); err != nil {
+		return nil, err
+	}
+
+	return url, nil
+}
+
+func (u *userServ) SetURLDisabled(c context.Context, userID string, urlID string, disabled bool) (*model.Url, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	url, err := u.getAuthorizedUrlByID(ctx, userID, urlID, model.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	url.Disabled = disabled
+	url.UpdatedAt = time.Now()
+
+	if err := u.saveUrl(ctx, url); err != nil {
+		return nil, err
+	}
+
+	return url, nil
+}
+
+// DeleteURL soft-deletes a link. It stops redirecting and disappears from
+// listings, but its click history is kept.
+func (u *userServ) DeleteURL(c context.Context, userID string, urlID string) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	url, err := u.getAuthorizedUrlByID(ctx, userID, urlID, model.RoleEditor)
+	if err != nil {
+		return err
+	}
+
+	err = u.repository.DeleteUrl(ctx, url.UrlID, time.Now())
+	u.invalidateUrl(ctx, url)
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusNotFound, Message: "url not found"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+func (u *userServ) saveUrl(ctx context.Context, url *model.Url) error {
+	err := u.repository.UpdateUrl(ctx, url)
+	// Even a failed update may have been applied.
+	u.invalidateUrl(ctx, url)
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusNotFound, Message: "url not found"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+// getAuthorizedUrlByID loads a link that has not been deleted and checks
+// that userID may act on it with at least role.
+func (u *userServ) getAuthorizedUrlByID(ctx context.Context, userID string, urlID string, role string) (*model.Url, error) {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	id, err := primitive.ObjectIDFromHex(urlID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid url id"}
+	}
+
+	url, err := u.repository.GetUrlByID(ctx, id)
+	if err == mongo.ErrNoDocuments || (err == nil && url.DeletedAt != nil) {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "url not found"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if err := u.authorizeUrl(ctx, uid, url, role); err != nil {
+		return nil, err
+	}
+
+	return url, nil
+}
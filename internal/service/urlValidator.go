@@ -0,0 +1,119 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const defaultMaxURLLength = 2048
+
+// urlValidator checks and normalizes destination URLs before they are
+// stored. Hosts are lowercased and converted to punycode, default ports are
+// dropped, and links back to the shortener itself are refused.
+type urlValidator struct {
+	schemes   map[string]bool
+	ownHosts  map[string]bool
+	maxLength int
+}
+
+// newURLValidatorFromEnv reads URL_ALLOWED_SCHEMES (default "http,https")
+// and SHORT_URL_DOMAINS, the hosts this service answers on.
+func newURLValidatorFromEnv() *urlValidator {
+	schemes := os.Getenv("URL_ALLOWED_SCHEMES")
+	if schemes == "" {
+		schemes = "http,https"
+	}
+
+	v := &urlValidator{
+		schemes:   make(map[string]bool),
+		ownHosts:  make(map[string]bool),
+		maxLength: defaultMaxURLLength,
+	}
+
+	for _, scheme := range strings.Split(schemes, ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			v.schemes[scheme] = true
+		}
+	}
+
+	for _, host := range strings.Split(os.Getenv("SHORT_URL_DOMAINS"), ",") {
+		if host, err := idna.Lookup.ToASCII(strings.TrimSpace(host)); err == nil && host != "" {
+			v.ownHosts[host] = true
+		}
+	}
+
+	return v
+}
+
+// Normalize returns the canonical form of raw, or an error message suitable
+// for a FieldError.
+func (v *urlValidator) Normalize(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("is required")
+	}
+
+	if len(raw) > v.maxLength {
+		return "", fmt.Errorf("must be at most %d characters", v.maxLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("is not a valid URL")
+	}
+
+	if u.Scheme == "" {
+		return "", fmt.Errorf("must be an absolute URL")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !v.schemes[u.Scheme] {
+		return "", fmt.Errorf("scheme %q is not allowed", u.Scheme)
+	}
+
+	if u.Opaque != "" || u.Host == "" {
+		return "", fmt.Errorf("must include a host")
+	}
+
+	// https://trusted.com@evil.com is a common way to disguise a target.
+	if u.User != nil {
+		return "", fmt.Errorf("must not contain credentials")
+	}
+
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	if net.ParseIP(host) == nil {
+		host, err = idna.Lookup.ToASCII(host)
+		if err != nil || host == "" {
+			return "", fmt.Errorf("has an invalid host")
+		}
+	}
+
+	if v.ownHosts[host] {
+		return "", fmt.Errorf("must not point back to this shortener")
+	}
+
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	normalized := u.String()
+	if len(normalized) > v.maxLength {
+		return "", fmt.Errorf("must be at most %d characters", v.maxLength)
+	}
+
+	return normalized, nil
+}
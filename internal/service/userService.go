@@ -2,29 +2,110 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"example.com/url-shortener/internal/model"
 	"example.com/url-shortener/utils"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type userServ struct {
 	repository model.UserRepositoryInterface
+	keyGen     *keyGenerator
+	clicks     *ClickPipeline
+	passwords  *attemptLimiter
+	resets     *attemptLimiter
+	urls       *urlValidator
+	blocklist  *Blocklist
+	resolver   TXTResolver
+	mailer     Mailer
+	cache      *UrlCache
+	sessions   *activeSessions
 }
 
-func NewUserService(repository model.UserRepositoryInterface) model.UserServiceInterface {
+func NewUserService(repository model.UserRepositoryInterface, clicks *ClickPipeline, blocklist *Blocklist, resolver TXTResolver, mailer Mailer, cache *UrlCache) model.UserServiceInterface {
+	if blocklist == nil {
+		blocklist = NewBlocklist(&memoryRuleSource{})
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if mailer == nil {
+		mailer = logMailer{}
+	}
+	if cache == nil {
+		cache = NewUrlCache(0, 0, 0, nil)
+	}
+
 	return &userServ{
-		repository,
+		repository: repository,
+		keyGen:     newKeyGeneratorFromEnv(),
+		clicks:     clicks,
+		passwords:  newAttemptLimiterFromEnv("LINK_PASSWORD_MAX_ATTEMPTS", 5, "LINK_PASSWORD_WINDOW", 15*time.Minute),
+		resets:     newAttemptLimiterFromEnv("PASSWORD_RESET_MAX_REQUESTS", 5, "PASSWORD_RESET_WINDOW", time.Hour),
+		urls:       newURLValidatorFromEnv(),
+		blocklist:  blocklist,
+		resolver:   resolver,
+		mailer:     mailer,
+		cache:      cache,
+		sessions:   newActiveSessions(),
 	}
 }
 
-func (u *userServ) Signup(c context.Context, userReq *model.CreateUserReq) (*model.SignupLoginUserRes, error) {
+// newAttemptLimiterFromEnv reads the limit from maxVar and the window from
+// windowVar, falling back to max and window. It limits wrong link passwords
+// (LINK_PASSWORD_MAX_ATTEMPTS, LINK_PASSWORD_WINDOW) and password reset
+// requests (PASSWORD_RESET_MAX_REQUESTS, PASSWORD_RESET_WINDOW).
+func newAttemptLimiterFromEnv(maxVar string, max int, windowVar string, window time.Duration) *attemptLimiter {
+	if n, err := strconv.Atoi(os.Getenv(maxVar)); err == nil && n > 0 {
+		max = n
+	}
+
+	if d, err := time.ParseDuration(os.Getenv(windowVar)); err == nil && d > 0 {
+		window = d
+	}
+
+	return newAttemptLimiter(max, window)
+}
+
+var reservedKeys = map[string]bool{
+	"signup":       true,
+	"login":        true,
+	"refresh":      true,
+	"logout":       true,
+	"create-url":   true,
+	"get-all-urls": true,
+	"product":      true,
+	"pricing":      true,
+	"dashboard":    true,
+	"create":       true,
+	"urls":         true,
+	"domains":      true,
+	"admin":        true,
+	"workspaces":   true,
+	"api-keys":     true,
+	"sessions":     true,
+	"verify-email": true,
+	"password":     true,
+}
+
+// maxKeyAttempts bounds how many generated keys CreateURL tries before
+// giving up.
+const maxKeyAttempts = 10
+
+func (u *userServ) Signup(c context.Context, userReq *model.CreateUserReq, meta *model.SessionMeta) (*model.SignupLoginUserRes, error) {
 	ctx, cancel := context.WithTimeout(c, 3*time.Second)
 	defer cancel()
 
@@ -50,36 +131,37 @@ func (u *userServ) Signup(c context.Context, userReq *model.CreateUserReq) (*mod
 		Created_at: time.Now(),
 	}
 
-	accessToken, err := utils.GenerateAccessToken(&s, os.Getenv("ACCESS_TOKEN_SECRET"), 10)
-	if err != nil {
-		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	// The check above is only a fast path; two signups racing for the same
+	// email are settled by the unique index.
+	err = u.repository.Signup(ctx, &s)
+	if errors.Is(err, model.ErrDuplicate) {
+		return nil, &utils.AppError{Code: http.StatusConflict, Message: "email already exist"}
 	}
-
-	refreshToken, err := utils.GenerateRefreshToken(&s, os.Getenv("REFRESH_TOKEN_SECRET"), 72)
 	if err != nil {
 		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
 
-	s.RefreshToken = refreshToken
-	s.RefreshTokenIssuedAT = time.Now()
+	if err := u.sendEmailVerification(ctx, &s); err != nil {
+		log.Printf("failed to start email verification for %s: %v", s.UserID.Hex(), err)
+	}
 
-	err = u.repository.Signup(ctx, &s)
+	tokens, err := u.startSession(ctx, &s, meta)
 	if err != nil {
-		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		return nil, err
 	}
 
 	res := &model.SignupLoginUserRes{
 		UserID:       s.UserID,
 		FullName:     s.FullName,
-		Email:  s.Email,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		Email:        s.Email,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}
 
 	return res, nil
 }
 
-func (u *userServ) Login(c context.Context, loginReq *model.LoginUserReq) (*model.SignupLoginUserRes, error) {
+func (u *userServ) Login(c context.Context, loginReq *model.LoginUserReq, meta *model.SessionMeta) (*model.SignupLoginUserRes, error) {
 	ctx, cancel := context.WithTimeout(c, 3*time.Second)
 	defer cancel()
 
@@ -93,86 +175,281 @@ func (u *userServ) Login(c context.Context, loginReq *model.LoginUserReq) (*mode
 		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "wrong password"}
 	}
 
-	accessToken, err := utils.GenerateAccessToken(user, os.Getenv("ACCESS_TOKEN_SECRET"), 10)
-	if err != nil {
-		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
-	}
-
-	refreshToken, err := utils.GenerateRefreshToken(user, os.Getenv("REFRESH_TOKEN_SECRET"), 72)
-	if err != nil {
-		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	if requireVerifiedEmail() && !user.EmailVerified {
+		return nil, &utils.AppError{Code: http.StatusForbidden, Message: "email not verified"}
 	}
 
-	err = u.repository.UpdateRefreshTokenInDB(ctx, user.UserID, refreshToken, time.Now())
-
+	tokens, err := u.startSession(ctx, user, meta)
 	if err != nil {
-		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		return nil, err
 	}
 
 	res := &model.SignupLoginUserRes{
-		UserID:       user.UserID,
-		FullName:     user.FullName,
-		Email:  user.Email,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		UserID:        user.UserID,
+		FullName:      user.FullName,
+		Email:         user.Email,
+		AccessToken:   tokens.AccessToken,
+		RefreshToken:  tokens.RefreshToken,
+		EmailVerified: user.EmailVerified,
 	}
 
 	return res, nil
 }
 
-func (u *userServ) CreateURL(c context.Context, userID string, urlReq *model.CreateUrlReq) (string, error) {
+func (u *userServ) CreateURL(c context.Context, userID string, urlReq *model.CreateUrlReq) (*model.Url, error) {
 	ctx, cancel := context.WithTimeout(c, 3*time.Second)
 	defer cancel()
 
-	wordSet := make(map[string]bool)
-	words := []string{"signup", "login", "refresh", "logout", "create-url", "get-all-urls", "product", "pricing", "dashboard", "create"}
+	uID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
 
-	for _, word := range words {
-		wordSet[word] = true
+	newUrl, err := u.newUrl(ctx, uID, urlReq, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	if wordSet[urlReq.ShortURLKey] {
-		return "", &utils.AppError{Code: http.StatusBadRequest, Message: "endpoint is reserved, not allowed to use"}
+	if err := u.insertUrl(ctx, newUrl, urlReq.ShortURLKey == ""); err != nil {
+		return nil, err
 	}
 
-	count, err := u.repository.CheckUniqueUrlKey(ctx, urlReq.ShortURLKey)
+	return newUrl, nil
+}
 
-	if err != nil {
-		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+// insertUrl stores url. newUrl's key check can be overtaken by another
+// request, so the store's unique index has the final say: a chosen key that
+// lost the race is reported as taken, a generated one is replaced.
+func (u *userServ) insertUrl(ctx context.Context, url *model.Url, generated bool) error {
+	for attempt := 1; ; attempt++ {
+		err := u.repository.InsertUrl(ctx, url)
+		if err == nil {
+			u.invalidateUrl(ctx, url)
+			return nil
+		}
+
+		if !errors.Is(err, model.ErrDuplicate) {
+			return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+
+		if !generated {
+			return &utils.AppError{Code: http.StatusBadRequest, Message: "endpoint already used"}
+		}
+
+		u.keyGen.Collision()
+		if attempt >= maxKeyAttempts {
+			return &utils.AppError{Code: http.StatusServiceUnavailable, Message: "could not generate a unique key, try again"}
+		}
+
+		key, err := u.generateUniqueKey(ctx, url.Domain, nil)
+		if err != nil {
+			return err
+		}
+		url.ShortURLKey = key
 	}
+}
 
-	if count > 0 {
-		return "", &utils.AppError{Code: http.StatusBadRequest, Message: "endpoint already used"}
+// newUrl validates urlReq and builds the link to store, generating a key if
+// none was given. taken holds takenKey values already claimed by other links
+// in the same request and may be nil.
+func (u *userServ) newUrl(ctx context.Context, uID primitive.ObjectID, urlReq *model.CreateUrlReq, taken map[string]bool) (*model.Url, error) {
+	longURL, fallbackURL := urlReq.LongURL, urlReq.FallbackURL
+	err := u.normalizeURLs(ctx,
+		urlField{name: "long_url", value: &longURL},
+		urlField{name: "fallback_url", value: &fallbackURL, optional: true},
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	uID, err := primitive.ObjectIDFromHex(userID)
+	redirectType, err := validateRedirectType(urlReq.RedirectType)
 	if err != nil {
-		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		return nil, err
+	}
+
+	if urlReq.ExpiresAt != nil && !urlReq.ExpiresAt.After(time.Now()) {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "expires_at must be in the future"}
+	}
+
+	if urlReq.MaxClicks < 0 {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "max_clicks cannot be negative"}
+	}
+
+	var passwordHash string
+	if urlReq.Password != "" {
+		passwordHash, err = utils.HashPassword(urlReq.Password)
+		if err != nil {
+			return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+	}
+
+	var domain string
+	if urlReq.Domain != "" {
+		domain, err = u.ownedDomain(ctx, uID, urlReq.Domain)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var workspaceID *primitive.ObjectID
+	if urlReq.WorkspaceID != "" {
+		wid, err := primitive.ObjectIDFromHex(urlReq.WorkspaceID)
+		if err != nil {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid workspace id"}
+		}
+		if _, err := u.workspaceRole(ctx, uID, wid, model.RoleEditor); err != nil {
+			return nil, err
+		}
+		workspaceID = &wid
+	}
+
+	key := urlReq.ShortURLKey
+	if key == "" {
+		generated, err := u.generateUniqueKey(ctx, domain, taken)
+		if err != nil {
+			return nil, err
+		}
+		key = generated
+	} else {
+		if reservedKeys[key] {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "endpoint is reserved, not allowed to use"}
+		}
+
+		if taken[takenKey(domain, key)] {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "endpoint already used"}
+		}
+
+		count, err := u.repository.CheckUniqueUrlKey(ctx, domain, key)
+
+		if err != nil {
+			return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+
+		if count > 0 {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "endpoint already used"}
+		}
+	}
+
+	return &model.Url{
+		UrlID:        primitive.NewObjectID(),
+		UserID:       uID,
+		WorkspaceID:  workspaceID,
+		Label:        urlReq.Label,
+		LongURL:      longURL,
+		ShortURLKey:  key,
+		Domain:       domain,
+		RedirectType: redirectType,
+		ExpiresAt:    urlReq.ExpiresAt,
+		MaxClicks:    urlReq.MaxClicks,
+		FallbackURL:  fallbackURL,
+		Protected:    passwordHash != "",
+		PasswordHash: passwordHash,
+		Tags:         urlReq.Tags,
+		NoOfClicks:   0,
+		Device:       make(map[string]int),
+		Location:     make(map[string]int),
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+type urlField struct {
+	name     string
+	value    *string
+	optional bool
+}
+
+// normalizeURLs validates each given URL and replaces it with its
+// normalized form, reporting every invalid or blocked field at once.
+func (u *userServ) normalizeURLs(ctx context.Context, fields ...urlField) error {
+	var errs []utils.FieldError
+
+	for _, f := range fields {
+		if f.value == nil || (f.optional && *f.value == "") {
+			continue
+		}
+
+		normalized, err := u.urls.Normalize(*f.value)
+		if err != nil {
+			errs = append(errs, utils.FieldError{Field: f.name, Message: err.Error()})
+			continue
+		}
+
+		if rule := u.blocklist.Match(normalized); rule != nil && rule.Action == "block" {
+			errs = append(errs, utils.FieldError{Field: f.name, Message: "points to a blocked destination"})
+			continue
+		}
+
+		// Verified custom domains serve this shortener's links too, so a
+		// destination on one of them would redirect back into it.
+		if parsed, err := url.Parse(normalized); err == nil && net.ParseIP(parsed.Hostname()) == nil {
+			_, err := u.repository.GetDomainByHost(ctx, parsed.Hostname())
+			if err == nil {
+				errs = append(errs, utils.FieldError{Field: f.name, Message: "must not point back to this shortener"})
+				continue
+			}
+			if err != mongo.ErrNoDocuments {
+				return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+			}
+		}
+
+		*f.value = normalized
 	}
 
-	var temp = make(map[string]int)
+	if len(errs) > 0 {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "invalid url", Fields: errs}
+	}
 
-	newUrl := &model.Url{
-		UrlID:       primitive.NewObjectID(),
-		UserID:      uID,
-		Label:       urlReq.Label,
-		LongURL:     urlReq.LongURL,
-		ShortURLKey: urlReq.ShortURLKey,
-		NoOfClicks:  0,
-		Device:      temp,
-		Location:    temp,
-		CreatedAt:   time.Now(),
+	return nil
+}
+
+func validateRedirectType(redirectType int) (int, error) {
+	switch redirectType {
+	case 0:
+		return http.StatusFound, nil
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return redirectType, nil
 	}
 
-	err = u.repository.InsertUrl(ctx, newUrl)
-	if err != nil {
-		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	return 0, &utils.AppError{Code: http.StatusBadRequest, Message: "redirect_type must be one of 301, 302, 307 or 308"}
+}
+
+func takenKey(domain string, key string) string {
+	return domain + "/" + key
+}
+
+func (u *userServ) generateUniqueKey(ctx context.Context, domain string, taken map[string]bool) (string, error) {
+	for i := 0; i < maxKeyAttempts; i++ {
+		key, err := u.keyGen.Generate()
+		if err != nil {
+			return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+
+		if reservedKeys[key] || taken[takenKey(domain, key)] {
+			continue
+		}
+
+		count, err := u.repository.CheckUniqueUrlKey(ctx, domain, key)
+		if err != nil {
+			return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+
+		if count == 0 {
+			u.keyGen.Accepted()
+			return key, nil
+		}
+
+		u.keyGen.Collision()
 	}
 
-	return newUrl.UserID.Hex(), nil
+	return "", &utils.AppError{Code: http.StatusServiceUnavailable, Message: "could not generate a unique key, try again"}
 }
 
-func (u *userServ) GetAllURLs(c context.Context, userID string) (*[]model.Url, error) {
+const (
+	defaultUrlPageSize = 50
+	maxUrlPageSize     = 500
+)
+
+func (u *userServ) GetAllURLs(c context.Context, userID string, query *model.ListUrlsQuery) (*model.UrlPage, error) {
 	ctx, cancel := context.WithTimeout(c, 3*time.Second)
 	defer cancel()
 
@@ -181,109 +458,312 @@ func (u *userServ) GetAllURLs(c context.Context, userID string) (*[]model.Url, e
 		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
 
-	res, err := u.repository.GetAllURLs(ctx, uid)
+	if query.Sort == "" {
+		query.Sort = "created_at"
+	}
+	if query.Sort != "created_at" && query.Sort != "clicks" && query.Sort != "label" {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "sort must be created_at, clicks or label"}
+	}
+
+	if query.Order == "" {
+		query.Order = "desc"
+	}
+	if query.Order != "asc" && query.Order != "desc" {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "order must be asc or desc"}
+	}
+
+	switch query.Status {
+	case "", "enabled", "disabled", "expired", "active":
+	default:
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "status must be enabled, disabled, expired or active"}
+	}
+
+	if query.WorkspaceID != "" {
+		member, err := u.authorizeWorkspace(ctx, userID, query.WorkspaceID, model.RoleViewer)
+		if err != nil {
+			return nil, err
+		}
+		query.Workspace = &member.WorkspaceID
+	}
+
+	if query.Limit <= 0 {
+		query.Limit = defaultUrlPageSize
+	}
+	if query.Limit > maxUrlPageSize {
+		query.Limit = maxUrlPageSize
+	}
+
+	if query.Cursor != "" {
+		after, err := decodeUrlCursor(query.Cursor)
+		if err != nil || after.Sort != query.Sort || after.Order != query.Order || after.Filters != urlFilterHash(query) {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid cursor"}
+		}
+		query.After = after
+	}
+
+	// Ask for one extra row to learn whether there is a next page.
+	limit := query.Limit
+	query.Limit++
+
+	urls, err := u.repository.GetAllURLs(ctx, uid, query)
 	if err != nil {
 		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
 
-	return res, nil
+	page := &model.UrlPage{Urls: urls}
+	if len(urls) > limit {
+		page.Urls = urls[:limit]
+		page.NextCursor = encodeUrlCursor(query, &page.Urls[limit-1])
+	}
+
+	return page, nil
 }
 
-func (u *userServ) RefreshAccessToken(c context.Context, refreshToken string) (*string, error) {
+func encodeUrlCursor(query *model.ListUrlsQuery, last *model.Url) string {
+	cursor := model.UrlCursor{Sort: query.Sort, Order: query.Order, Filters: urlFilterHash(query), ID: last.UrlID}
+	switch query.Sort {
+	case "clicks":
+		cursor.Clicks = last.NoOfClicks
+	case "label":
+		cursor.Label = last.Label
+	default:
+		cursor.CreatedAt = last.CreatedAt
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// urlFilterHash sums up the filters of query, so a cursor can tell whether
+// it is being used with the ones that produced it.
+func urlFilterHash(query *model.ListUrlsQuery) string {
+	filters := []string{
+		query.Label,
+		query.Domain,
+		query.CreatedFrom.UTC().Format(time.RFC3339Nano),
+		query.CreatedTo.UTC().Format(time.RFC3339Nano),
+		query.Status,
+		query.WorkspaceID,
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(filters, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+func decodeUrlCursor(s string) (*model.UrlCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor model.UrlCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+
+	return &cursor, nil
+}
+
+const (
+	defaultClickPageSize = 50
+	maxClickPageSize     = 500
+)
+
+func (u *userServ) ListClickEvents(c context.Context, userID string, domain string, key string, filter *model.ClickEventFilter) (*model.ClickEventPage, error) {
 	ctx, cancel := context.WithTimeout(c, 3*time.Second)
 	defer cancel()
 
-	if refreshToken == "" {
-		// c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "invalid refresh token"}
+	url, err := u.getAuthorizedUrlByKey(ctx, userID, domain, key, model.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	filter.Key = url.ShortURLKey
+	filter.Domain = url.Domain
+
+	if filter.Cursor != "" {
+		before, err := primitive.ObjectIDFromHex(filter.Cursor)
+		if err != nil {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid cursor"}
+		}
+		filter.Before = before
 	}
 
-	userID, err := utils.ValidateToken(refreshToken, os.Getenv("REFRESH_TOKEN_SECRET"))
-	if err != nil {
-		// c.JSON(http.StatusUnauthorized, gin.H{"error": "token error"})
-		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "invalid refresh token"}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultClickPageSize
+	}
+	if filter.Limit > maxClickPageSize {
+		filter.Limit = maxClickPageSize
 	}
 
-	uid, err := primitive.ObjectIDFromHex(userID)
+	events, err := u.repository.FindClickEvents(ctx, filter)
 	if err != nil {
 		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
 
-	res, err := u.repository.GetUserById(ctx, uid)
+	page := &model.ClickEventPage{Events: events}
+	if len(events) == filter.Limit {
+		page.NextCursor = events[len(events)-1].ID.Hex()
+	}
+
+	return page, nil
+}
+
+func (u *userServ) checkLinkPassword(url *model.Url, password string) error {
+	if password == "" {
+		return &utils.AppError{Code: http.StatusUnauthorized, Message: "password required"}
+	}
+
+	// The same key on another domain is another link.
+	limitKey := takenKey(url.Domain, url.ShortURLKey)
+	if !u.passwords.Reserve(limitKey) {
+		return &utils.AppError{Code: http.StatusTooManyRequests, Message: "too many wrong passwords, try again later"}
+	}
+
+	if err := utils.VerifyPassword(password, url.PasswordHash); err != nil {
+		return &utils.AppError{Code: http.StatusUnauthorized, Message: "wrong password"}
+	}
 
+	u.passwords.Refund(limitKey)
+	return nil
+}
+
+// getAuthorizedUrlByKey loads the link behind key on domain and checks that
+// userID may act on it with at least role.
+func (u *userServ) getAuthorizedUrlByKey(ctx context.Context, userID string, domain string, key string, role string) (*model.Url, error) {
+	uid, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
 
-	if refreshToken != res.RefreshToken {
-		return nil, &utils.AppError{Code: http.StatusUnauthorized, Message: "expired refresh token"}
+	if domain != "" {
+		if domain, err = normalizeHost(domain); err != nil {
+			return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid domain"}
+		}
 	}
 
-	accessToken, err := utils.GenerateAccessToken(res, os.Getenv("ACCESS_TOKEN_SECRET"), 10)
+	url, err := u.repository.GetUrlByKey(ctx, domain, key)
+	if err == mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "url not found"}
+	}
 	if err != nil {
 		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
 
-	return &accessToken, nil
+	if err := u.authorizeUrl(ctx, uid, url, role); err != nil {
+		return nil, err
+	}
+
+	return url, nil
 }
 
-func (u *userServ) Logout(c context.Context, token string) error {
+func (u *userServ) RedirectURL(c context.Context, click *model.ClickEvent, password string, confirmed bool) (*model.Url, error) {
 	ctx, cancel := context.WithTimeout(c, 3*time.Second)
 	defer cancel()
 
-	uID, err := utils.ValidateToken(token, os.Getenv("ACCESS_TOKEN_SECRET"))
+	domain, err := u.linkDomain(ctx, click.Domain)
 	if err != nil {
-		return &utils.AppError{Code: http.StatusUnauthorized, Message: err.Error()}
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
+	click.Domain = domain
 
-	userID, err := primitive.ObjectIDFromHex(uID)
-	if err != nil {
-		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	url, err := u.getUrlByKey(ctx, domain, click.Key)
+	if err == mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "link not found"}
 	}
-	err = u.repository.UpdateRefreshTokenInDB(ctx, userID, nil, time.Now())
 	if err != nil {
-		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
 	}
-	return nil
-}
 
-type geolocation struct {
-	Location string `json:"city"`
-}
+	if url.DeletedAt != nil {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "link not found"}
+	}
 
-func (u *userServ) RedirectURL(c context.Context, key string, ip string, device string) (string, error) {
-	ctx, cancel := context.WithTimeout(c, 3*time.Second)
-	defer cancel()
+	if url.Disabled {
+		return nil, &utils.AppError{Code: http.StatusForbidden, Message: "link is disabled"}
+	}
 
-	count, _ := u.repository.CheckUniqueUrlKey(ctx, key)
-	if count < 1 {
-		return "", &utils.AppError{Code: http.StatusBadRequest, Message: "invalid enpoint"}
+	if url.IsExpired(time.Now()) {
+		return u.expiredUrl(url)
 	}
 
-	url := fmt.Sprintf("http://ip-api.com/json/%s", ip)
+	// Rules are checked on every redirect so that existing links stop
+	// working as soon as their destination is blocked.
+	if rule := u.blocklist.Match(url.LongURL); rule != nil {
+		if rule.Action == "block" {
+			return url, &utils.AppError{Code: http.StatusForbidden, Message: "destination is blocked"}
+		}
+		if !confirmed {
+			return url, &utils.AppError{Code: http.StatusConflict, Message: "destination has been flagged as possibly harmful"}
+		}
+	}
 
-	res, err := http.Get(url)
-	if err != nil {
-		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	if url.PasswordHash != "" {
+		if err := u.checkLinkPassword(url, password); err != nil {
+			return url, err
+		}
 	}
-	defer res.Body.Close()
 
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	// The counter the pipeline keeps lags behind and may drop clicks, so a
+	// limited link takes its click here, atomically, before redirecting.
+	if url.MaxClicks > 0 {
+		err := u.repository.TakeClick(ctx, url.UrlID)
+		if err == mongo.ErrNoDocuments {
+			url.Expired = true
+			return u.expiredUrl(url)
+		}
+		if err != nil {
+			return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+		click.Counted = true
 	}
 
-	var location geolocation
+	click.At = time.Now()
+	u.clicks.Enqueue(click)
 
-	err = json.Unmarshal(data, &location)
-	if err != nil {
-		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	return url, nil
+}
+
+// expiredUrl answers for a link that has expired. The gone page sends
+// visitors on to the fallback without a chance to confirm, so a fallback
+// that matches any rule is dropped rather than followed.
+func (u *userServ) expiredUrl(url *model.Url) (*model.Url, error) {
+	if url.FallbackURL != "" && u.blocklist.Match(url.FallbackURL) != nil {
+		url.FallbackURL = ""
+	}
+	return url, &utils.AppError{Code: http.StatusGone, Message: "link has expired"}
+}
+
+// getUrlByKey resolves key through the cache. Links with a click limit are
+// always read fresh, since every redirect brings them closer to expiring.
+func (u *userServ) getUrlByKey(ctx context.Context, domain string, key string) (*model.Url, error) {
+	cacheKey := takenKey(domain, key)
+
+	url, ok, generation := u.cache.Get(cacheKey)
+	if ok {
+		if url == nil {
+			return nil, mongo.ErrNoDocuments
+		}
+		return url, nil
 	}
 
-	out, err := u.repository.UpdateUrlInfoInDB(ctx, key, device, location.Location)
+	url, err := u.repository.GetUrlByKey(ctx, domain, key)
+	if err == mongo.ErrNoDocuments {
+		u.cache.Set(cacheKey, nil, generation)
+		return nil, err
+	}
 	if err != nil {
-		return "", &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		return nil, err
+	}
+
+	if url.MaxClicks == 0 {
+		u.cache.Set(cacheKey, url, generation)
 	}
+	return url, nil
+}
 
-	return out.LongURL, nil
+// invalidateUrl is called after every change to a stored link, including
+// creating one whose key may be cached as missing.
+func (u *userServ) invalidateUrl(ctx context.Context, url *model.Url) {
+	u.cache.Invalidate(ctx, takenKey(url.Domain, url.ShortURLKey))
 }
@@ -0,0 +1,430 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"example.com/url-shortener/internal/model"
+	"example.com/url-shortener/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const workspaceInviteTTL = 7 * 24 * time.Hour
+
+// roleRank orders workspace roles; each role can do everything the ones
+// below it can. Viewers read links and stats, editors create and change
+// links, admins manage members and owners also manage admins.
+var roleRank = map[string]int{
+	model.RoleViewer: 1,
+	model.RoleEditor: 2,
+	model.RoleAdmin:  3,
+	model.RoleOwner:  4,
+}
+
+func (u *userServ) CreateWorkspace(c context.Context, userID string, req *model.CreateWorkspaceReq) (*model.Workspace, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "name is required"}
+	}
+
+	user, err := u.repository.GetUserById(ctx, uid)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	now := time.Now()
+	workspace := &model.Workspace{
+		WorkspaceID: primitive.NewObjectID(),
+		Name:        name,
+		OwnerID:     uid,
+		CreatedAt:   now,
+	}
+
+	if err := u.repository.InsertWorkspace(ctx, workspace); err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	owner := &model.WorkspaceMember{
+		MemberID:    primitive.NewObjectID(),
+		WorkspaceID: workspace.WorkspaceID,
+		UserID:      &uid,
+		Email:       user.Email,
+		Role:        model.RoleOwner,
+		Status:      "active",
+		CreatedAt:   now,
+		JoinedAt:    &now,
+	}
+
+	if err := u.repository.InsertWorkspaceMember(ctx, owner); err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return workspace, nil
+}
+
+func (u *userServ) ListWorkspaces(c context.Context, userID string) ([]model.Workspace, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	res, err := u.repository.GetWorkspacesByUser(ctx, uid)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return res, nil
+}
+
+func (u *userServ) ListWorkspaceMembers(c context.Context, userID string, workspaceID string) ([]model.WorkspaceMember, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	member, err := u.authorizeWorkspace(ctx, userID, workspaceID, model.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := u.repository.GetWorkspaceMembers(ctx, member.WorkspaceID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return res, nil
+}
+
+// InviteMember records a pending membership for req.Email and mails the
+// invitee a link to accept it, which they do after signing in with that
+// email. The token is returned here too, in case the mail goes astray; it
+// works for a week. An expired invitation can be replaced by a new one.
+func (u *userServ) InviteMember(c context.Context, userID string, workspaceID string, req *model.InviteMemberReq) (*model.InviteMemberRes, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	inviter, err := u.authorizeWorkspace(ctx, userID, workspaceID, model.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid email"}
+	}
+
+	if err := checkAssignableRole(inviter, req.Role); err != nil {
+		return nil, err
+	}
+
+	members, err := u.repository.GetWorkspaceMembers(ctx, inviter.WorkspaceID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+	now := time.Now()
+	for _, m := range members {
+		if !strings.EqualFold(m.Email, addr.Address) {
+			continue
+		}
+		if m.Status != "invited" || !invitationExpired(&m, now) {
+			return nil, &utils.AppError{Code: http.StatusConflict, Message: "already a member or invited"}
+		}
+		if err := u.repository.DeleteWorkspaceMember(ctx, m.MemberID); err != nil && err != mongo.ErrNoDocuments {
+			return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+		}
+	}
+
+	workspace, err := u.repository.GetWorkspaceByID(ctx, inviter.WorkspaceID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	inviterUser, err := u.repository.GetUserById(ctx, *inviter.UserID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	expiresAt := now.Add(workspaceInviteTTL)
+	member := &model.WorkspaceMember{
+		MemberID:        primitive.NewObjectID(),
+		WorkspaceID:     inviter.WorkspaceID,
+		Email:           addr.Address,
+		Role:            req.Role,
+		Status:          "invited",
+		InviteTokenHash: utils.HashToken(token),
+		InvitedBy:       *inviter.UserID,
+		CreatedAt:       now,
+		ExpiresAt:       &expiresAt,
+	}
+
+	if err := u.repository.InsertWorkspaceMember(ctx, member); err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	u.sendMail(&Message{
+		To:      member.Email,
+		Subject: fmt.Sprintf("Join %s", workspace.Name),
+		Body: fmt.Sprintf("Hi,\n\n%s invited you to the workspace %s as %s. Sign in with this email address and open this link to join:\n\n%s/accept-invitation?token=%s\n\nThe link expires in 7 days.\n",
+			inviterUser.FullName, workspace.Name, member.Role, appURL(), url.QueryEscape(token)),
+	})
+
+	return &model.InviteMemberRes{Member: *member, Token: token}, nil
+}
+
+func (u *userServ) AcceptInvitation(c context.Context, userID string, req *model.AcceptInvitationReq) (*model.WorkspaceMember, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	member, err := u.repository.GetWorkspaceMemberByInviteToken(ctx, utils.HashToken(req.Token))
+	if err == mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "invitation not found"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	user, err := u.repository.GetUserById(ctx, uid)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if !strings.EqualFold(user.Email, member.Email) {
+		return nil, &utils.AppError{Code: http.StatusForbidden, Message: "invitation was sent to another email"}
+	}
+
+	now := time.Now()
+	if invitationExpired(member, now) {
+		return nil, &utils.AppError{Code: http.StatusGone, Message: "invitation has expired"}
+	}
+
+	if _, err := u.repository.GetWorkspaceMember(ctx, member.WorkspaceID, uid); err == nil {
+		return nil, &utils.AppError{Code: http.StatusConflict, Message: "already a member"}
+	} else if err != mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	member.UserID = &uid
+	member.Status = "active"
+	member.InviteTokenHash = ""
+	member.ExpiresAt = nil
+	member.JoinedAt = &now
+
+	if err := u.saveWorkspaceMember(ctx, member); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+func (u *userServ) UpdateMember(c context.Context, userID string, workspaceID string, memberID string, req *model.UpdateMemberReq) (*model.WorkspaceMember, error) {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	actor, err := u.authorizeWorkspace(ctx, userID, workspaceID, model.RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := u.getWorkspaceMember(ctx, actor.WorkspaceID, memberID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkManageableMember(actor, member); err != nil {
+		return nil, err
+	}
+
+	if err := checkAssignableRole(actor, req.Role); err != nil {
+		return nil, err
+	}
+
+	member.Role = req.Role
+
+	if err := u.saveWorkspaceMember(ctx, member); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// RemoveMember removes a member or withdraws an invitation. Any member may
+// remove themselves, except the owner.
+func (u *userServ) RemoveMember(c context.Context, userID string, workspaceID string, memberID string) error {
+	ctx, cancel := context.WithTimeout(c, 3*time.Second)
+	defer cancel()
+
+	actor, err := u.authorizeWorkspace(ctx, userID, workspaceID, model.RoleViewer)
+	if err != nil {
+		return err
+	}
+
+	member, err := u.getWorkspaceMember(ctx, actor.WorkspaceID, memberID)
+	if err != nil {
+		return err
+	}
+
+	if member.MemberID == actor.MemberID {
+		if member.Role == model.RoleOwner {
+			return &utils.AppError{Code: http.StatusBadRequest, Message: "the owner cannot leave the workspace"}
+		}
+	} else {
+		if roleRank[actor.Role] < roleRank[model.RoleAdmin] {
+			return &utils.AppError{Code: http.StatusForbidden, Message: "insufficient workspace role"}
+		}
+		if err := checkManageableMember(actor, member); err != nil {
+			return err
+		}
+	}
+
+	err = u.repository.DeleteWorkspaceMember(ctx, member.MemberID)
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusNotFound, Message: "member not found"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+// invitationExpired reports whether member's invitation can no longer be
+// accepted. Invitations made before they had an expiry last as long as new
+// ones do from when they were made.
+func invitationExpired(member *model.WorkspaceMember, now time.Time) bool {
+	expiresAt := member.CreatedAt.Add(workspaceInviteTTL)
+	if member.ExpiresAt != nil {
+		expiresAt = *member.ExpiresAt
+	}
+	return !now.Before(expiresAt)
+}
+
+// checkAssignableRole reports whether actor may give someone role. There is
+// only one owner, and only the owner appoints admins.
+func checkAssignableRole(actor *model.WorkspaceMember, role string) error {
+	if _, ok := roleRank[role]; !ok || role == model.RoleOwner {
+		return &utils.AppError{Code: http.StatusBadRequest, Message: "role must be admin, editor or viewer"}
+	}
+
+	if role == model.RoleAdmin && actor.Role != model.RoleOwner {
+		return &utils.AppError{Code: http.StatusForbidden, Message: "only the owner can appoint admins"}
+	}
+
+	return nil
+}
+
+// checkManageableMember reports whether actor may change or remove member:
+// nobody manages the owner and only the owner manages admins.
+func checkManageableMember(actor *model.WorkspaceMember, member *model.WorkspaceMember) error {
+	if member.Role == model.RoleOwner {
+		return &utils.AppError{Code: http.StatusForbidden, Message: "the owner cannot be changed"}
+	}
+
+	if member.Role == model.RoleAdmin && actor.Role != model.RoleOwner {
+		return &utils.AppError{Code: http.StatusForbidden, Message: "only the owner can manage admins"}
+	}
+
+	return nil
+}
+
+func (u *userServ) getWorkspaceMember(ctx context.Context, workspaceID primitive.ObjectID, memberID string) (*model.WorkspaceMember, error) {
+	id, err := primitive.ObjectIDFromHex(memberID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid member id"}
+	}
+
+	member, err := u.repository.GetWorkspaceMemberByID(ctx, id)
+	if err == mongo.ErrNoDocuments || (err == nil && member.WorkspaceID != workspaceID) {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "member not found"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return member, nil
+}
+
+func (u *userServ) saveWorkspaceMember(ctx context.Context, member *model.WorkspaceMember) error {
+	err := u.repository.UpdateWorkspaceMember(ctx, member)
+	if err == mongo.ErrNoDocuments {
+		return &utils.AppError{Code: http.StatusNotFound, Message: "member not found"}
+	}
+	if err != nil {
+		return &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	return nil
+}
+
+// authorizeWorkspace returns userID's membership in workspaceID if it has at
+// least role. Non-members get a 404 so workspace ids cannot be probed.
+func (u *userServ) authorizeWorkspace(ctx context.Context, userID string, workspaceID string, role string) (*model.WorkspaceMember, error) {
+	uid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	wid, err := primitive.ObjectIDFromHex(workspaceID)
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusBadRequest, Message: "invalid workspace id"}
+	}
+
+	return u.workspaceRole(ctx, uid, wid, role)
+}
+
+func (u *userServ) workspaceRole(ctx context.Context, uid primitive.ObjectID, workspaceID primitive.ObjectID, role string) (*model.WorkspaceMember, error) {
+	member, err := u.repository.GetWorkspaceMember(ctx, workspaceID, uid)
+	if err == mongo.ErrNoDocuments {
+		return nil, &utils.AppError{Code: http.StatusNotFound, Message: "workspace not found"}
+	}
+	if err != nil {
+		return nil, &utils.AppError{Code: http.StatusInternalServerError, Message: "internal server error"}
+	}
+
+	if roleRank[member.Role] < roleRank[role] {
+		return nil, &utils.AppError{Code: http.StatusForbidden, Message: "insufficient workspace role"}
+	}
+
+	return member, nil
+}
+
+// authorizeUrl checks that uid may act on url with at least role. Personal
+// links are only accessible to their creator; workspace links to members.
+func (u *userServ) authorizeUrl(ctx context.Context, uid primitive.ObjectID, url *model.Url, role string) error {
+	if url.WorkspaceID == nil {
+		if url.UserID != uid {
+			return &utils.AppError{Code: http.StatusForbidden, Message: "url belongs to another user"}
+		}
+		return nil
+	}
+
+	_, err := u.workspaceRole(ctx, uid, *url.WorkspaceID, role)
+	if appErr, ok := err.(*utils.AppError); ok && appErr.Code == http.StatusNotFound {
+		return &utils.AppError{Code: http.StatusForbidden, Message: "url belongs to another workspace"}
+	}
+	return err
+}
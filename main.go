@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"example.com/url-shortener/api/router"
 	"example.com/url-shortener/db"
+	"example.com/url-shortener/internal/model"
 	"example.com/url-shortener/internal/repository"
 	"example.com/url-shortener/internal/service"
 	"github.com/gin-gonic/gin"
@@ -17,11 +25,43 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+
 	r := gin.Default()
 
-	db := db.NewMongoDatabase()
-	rep := repository.NewUserRepository(db)
-	ser := service.NewUserService(rep)
+	rep := newRepository()
+
+	geo, err := service.NewGeoResolverFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bg, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	service.StartExpirySweeper(bg, rep)
+
+	blocklist, err := service.NewBlocklistFromEnv(rep)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blocklist.Start(bg)
+
+	mailer, err := service.NewMailerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cache, err := service.NewUrlCacheFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	clicks := service.NewClickPipelineFromEnv(rep, geo)
+	ser := service.NewUserService(rep, clicks, blocklist, nil, mailer, cache)
 	router.NewRouter(r, ser)
 
 	port := os.Getenv("PORT")
@@ -30,8 +70,126 @@ func main() {
 		port = "8080"
 	}
 
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("shutting down")
+	stopBackground()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+
+	if err := clicks.Shutdown(ctx); err != nil {
+		log.Printf("click pipeline did not drain: %v", err)
+	}
+}
+
+// migrateTimeout covers waiting for another instance to finish migrating
+// as well as building indexes on large collections.
+const migrateTimeout = 10 * time.Minute
+
+// newRepository picks the storage backend from DB_DRIVER: "mongo" (the
+// default), "sqlite", a single file at SQLITE_PATH, or "memory", which
+// keeps everything in process and loses it on restart. Pending migrations
+// run first unless SKIP_MIGRATIONS is "true", for deployments that run
+// "migrate" as a separate step.
+func newRepository() model.UserRepositoryInterface {
+	skipMigrations := os.Getenv("SKIP_MIGRATIONS") == "true"
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "mongo":
+		mongoDB := db.NewMongoDatabase()
+		// Without the unique indexes concurrent requests can claim the same
+		// key or email, so refuse to start rather than run unprotected.
+		if !skipMigrations {
+			if err := repository.MigrateMongo(ctx, mongoDB); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return repository.NewUserRepository(mongoDB)
+	case "sqlite":
+		sqlDB := db.NewSQLiteDatabase()
+		if !skipMigrations {
+			if err := repository.MigrateSQLite(ctx, sqlDB); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return repository.NewSQLiteRepository(sqlDB)
+	case "memory":
+		log.Println("using in-memory storage, data will not survive a restart")
+		return repository.NewMemoryRepository()
+	default:
+		log.Fatalf("unknown DB_DRIVER %q", driver)
+		return nil
+	}
+}
+
+// migrate runs the migrate subcommand: "migrate up", the default, applies
+// pending migrations and "migrate down [n]" reverts the last n, one unless
+// given.
+func migrate(args []string) {
+	direction := "up"
+	if len(args) > 0 {
+		direction = args[0]
+	}
+
+	if (direction != "up" && direction != "down") || (direction == "up" && len(args) > 1) || len(args) > 2 {
+		log.Fatalf("usage: %s migrate [up | down [n]]", os.Args[0])
+	}
+
+	steps := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			log.Fatalf("invalid number of migrations %q", args[1])
+		}
+		steps = n
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), migrateTimeout)
+	defer cancel()
+
+	var err error
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "mongo":
+		mongoDB := db.NewMongoDatabase()
+		if direction == "up" {
+			err = repository.MigrateMongo(ctx, mongoDB)
+		} else {
+			err = repository.MigrateMongoDown(ctx, mongoDB, steps)
+		}
+	case "sqlite":
+		if direction == "down" {
+			log.Fatal("sqlite migrations cannot be reverted")
+		}
+		err = repository.MigrateSQLite(ctx, db.NewSQLiteDatabase())
+	case "memory":
+		log.Println("in-memory storage has nothing to migrate")
+	default:
+		log.Fatalf("unknown DB_DRIVER %q", driver)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("migrate %s done", direction)
 }
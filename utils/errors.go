@@ -7,6 +7,13 @@ import (
 type AppError struct {
 	Code    int
 	Message string
+	Fields  []FieldError
+}
+
+// FieldError points at a single invalid field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func (a *AppError) ErrorCode() int {
@@ -18,5 +25,12 @@ func (a *AppError) Error() string {
 }
 
 func CjsonError(c *gin.Context, err error) {
-	c.JSON(err.(*AppError).ErrorCode(), gin.H{"error": err.(*AppError).Error()})
+	appErr := err.(*AppError)
+
+	body := gin.H{"error": appErr.Error()}
+	if len(appErr.Fields) > 0 {
+		body["fields"] = appErr.Fields
+	}
+
+	c.JSON(appErr.ErrorCode(), body)
 }
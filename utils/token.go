@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -8,11 +12,12 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func GenerateAccessToken(user *model.User, secret string, expiry int) (string, error) {
+func GenerateAccessToken(user *model.User, sessionID string, secret string, expiry int) (string, error) {
 	exp := time.Now().Add(time.Hour * time.Duration(expiry))
 	claims := &model.JwtCustomAccessClaims{
-		UserID: user.UserID.Hex(),
-		Name:   user.FullName,
+		UserID:    user.UserID.Hex(),
+		Name:      user.FullName,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(exp),
 		},
@@ -22,32 +27,45 @@ func GenerateAccessToken(user *model.User, secret string, expiry int) (string, e
 	return t.SignedString([]byte(secret))
 }
 
-func GenerateRefreshToken(user *model.User, secret string, expiry int) (string, error) {
-	exp := time.Now().Add(time.Hour * time.Duration(expiry))
-	claims := &model.JwtCustomRefreshClaims{
-		UserID: user.UserID.Hex(),
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(exp),
-		},
+// ParseAccessToken only accepts HS256 tokens signed with secret that carry
+// an expiry, so neither "none" nor a forged algorithm nor a token that never
+// expires gets through.
+func ParseAccessToken(token string, secret string) (*model.JwtCustomAccessClaims, error) {
+	claims := &model.JwtCustomAccessClaims{}
+	t, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return t.SignedString([]byte(secret))
-}
+	if !t.Valid {
+		return nil, errors.New("invalid token")
+	}
 
-func ValidateToken(token string, secret string) (string, error) {
-	t, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiry")
+	}
 
-	if err != nil {
-		return "", err
+	if claims.UserID == "" {
+		return nil, errors.New("token has no user")
 	}
 
-	claims, ok := t.Claims.(jwt.MapClaims)
-	if !ok && !t.Valid {
-		return "", errors.New("invalid token")
+	return claims, nil
+}
+
+// RandomToken returns n random bytes encoded for use in URLs.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	return claims["user_id"].(string), nil
+// HashToken is used to store high-entropy tokens at rest. Unlike passwords
+// they don't need a slow hash, and a plain digest can be looked up.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }